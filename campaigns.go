@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// campaignListColumns are the columns of the table and CSV campaign listings
+var campaignListColumns = []string{"id", "web_id", "send_time", "candidate", "title", "subject_line", "archive_url"}
+
+// campaignListEntry is a campaign as shown by the campaigns command. Candidate is
+// whether the current filters would allow it to be mirrored.
+type campaignListEntry struct {
+	Id          string `json:"id"`
+	WebId       int    `json:"web_id"`
+	SendTime    string `json:"send_time"`
+	Candidate   bool   `json:"candidate"`
+	Title       string `json:"title"`
+	SubjectLine string `json:"subject_line"`
+	ArchiveUrl  string `json:"archive_url"`
+}
+
+func (e campaignListEntry) values() []string {
+	return []string{e.Id, strconv.Itoa(e.WebId), e.SendTime, strconv.FormatBool(e.Candidate), e.Title, e.SubjectLine, e.ArchiveUrl}
+}
+
+// RunCampaignsCommand lists the most recently sent campaigns, MailChimpFetchCount of
+// them unless -count is given, as a table, JSON array or CSV. It returns the process
+// exit code.
+func RunCampaignsCommand(args []string) int {
+	flags := flag.NewFlagSet("campaigns", flag.ExitOnError)
+	format := flags.String("format", "table", "output format: table, json or csv")
+	count := flags.Int("count", 0, "number of campaigns to list, defaults to MailChimpFetchCount")
+	_ = flags.Parse(args)
+
+	if *format != "table" && *format != "json" && *format != "csv" {
+		fmt.Printf("Unknown format %q, expected table, json or csv\n", *format)
+		return 1
+	}
+
+	conf := ReadConfiguration()
+	if conf.MailChimpServerPrefix == "" || conf.MailChimpApiKey == "" {
+		fmt.Println("MailChimpServerPrefix and MailChimpApiKey are required to list campaigns")
+		return 1
+	}
+	ConfigureHttpTransport(conf)
+
+	if *count <= 0 {
+		*count = conf.MailChimpFetchCount
+	}
+
+	campaigns, err := ListMailChimpCampaigns(context.Background(), conf, nil, *count)
+	if err != nil {
+		fmt.Printf("Listing campaigns failed: %s\n", err)
+		return 1
+	}
+
+	candidates := map[int]bool{}
+	for _, i := range CandidateCampaigns(conf, campaigns) {
+		candidates[i] = true
+	}
+
+	entries := make([]campaignListEntry, 0, len(campaigns))
+	for i, campaign := range campaigns {
+		entries = append(entries, campaignListEntry{
+			Id:          campaign.Id,
+			WebId:       campaign.WebId,
+			SendTime:    campaign.SendTime,
+			Candidate:   candidates[i],
+			Title:       campaign.Settings.Title,
+			SubjectLine: campaign.Settings.SubjectLine,
+			ArchiveUrl:  campaign.UrlField(conf.MailChimpUrlField),
+		})
+	}
+
+	switch *format {
+	case "json":
+		err = writeCampaignsJson(entries)
+	case "csv":
+		err = writeCampaignsCsv(entries)
+	default:
+		err = writeCampaignsTable(entries)
+	}
+	if err != nil {
+		fmt.Printf("Writing campaigns failed: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func writeCampaignsTable(entries []campaignListEntry) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, column := range campaignListColumns {
+		if i > 0 {
+			fmt.Fprint(writer, "\t")
+		}
+		fmt.Fprint(writer, column)
+	}
+	fmt.Fprintln(writer)
+
+	for _, entry := range entries {
+		for i, value := range entry.values() {
+			if i > 0 {
+				fmt.Fprint(writer, "\t")
+			}
+			fmt.Fprint(writer, value)
+		}
+		fmt.Fprintln(writer)
+	}
+
+	return writer.Flush()
+}
+
+func writeCampaignsJson(entries []campaignListEntry) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// writeCampaignsCsv writes RFC 4180 CSV, which uses CRLF line endings
+func writeCampaignsCsv(entries []campaignListEntry) error {
+	writer := csv.NewWriter(os.Stdout)
+	writer.UseCRLF = true
+
+	if err := writer.Write(campaignListColumns); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writer.Write(entry.values()); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// ListMailChimpCampaigns fetches up to count sent campaigns, newest first
+func ListMailChimpCampaigns(ctx context.Context, conf Configuration, result *Result, count int) ([]MailChimpCampaign, error) {
+	listId, err := ResolveMailChimpListId(ctx, conf, result)
+	if err != nil {
+		return nil, err
+	}
+	conf.MailChimpListId = listId
+
+	url := MailChimpCampaignsUrl(conf, count)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+	resp, err := SendRequest(conf, result, "mailchimp", conf.MailChimpOkStatuses, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	sent := MailChimpSent{}
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		return nil, err
+	}
+
+	return sent.Campaigns, nil
+}
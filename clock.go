@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything time dependent, so schedules,
+// backoff and throttling can be exercised with a FakeClock instead of waiting
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// clock is used throughout instead of calling the time package directly
+var clock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock only moves when told to. Sleep and After wait until Advance moves the
+// clock past their deadline, so tests control exactly when timers fire.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	changed chan struct{}
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	fired    chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing every timer that is then due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			waiting = append(waiting, waiter)
+		} else {
+			waiter.fired <- c.now
+		}
+	}
+	c.waiters = waiting
+	c.notifyChanged()
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fired := make(chan time.Time, 1)
+	if d <= 0 {
+		fired <- c.now
+		return fired
+	}
+
+	c.waiters = append(c.waiters, fakeWaiter{deadline: c.now.Add(d), fired: fired})
+	c.notifyChanged()
+	return fired
+}
+
+// BlockUntil waits until n timers are waiting on the clock, so a test advances it
+// only once the code under test has started waiting
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notifyChanged wakes BlockUntil, with c.mu held
+func (c *FakeClock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
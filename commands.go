@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RunValidateCommand loads and validates the configuration without contacting any
+// service, printing OK or the problems found. It returns the process exit code.
+func RunValidateCommand() int {
+	conf := ReadConfiguration()
+
+	problems := conf.Validate()
+	if len(problems) > 0 {
+		fmt.Println(FormatProblems(problems))
+		return 1
+	}
+
+	fmt.Println("OK")
+	return 0
+}
+
+// RunSmokeCommand exercises the link get/update/verify path against the real
+// account by pushing SmokeTestUrl to the primary link, reading it back, and then
+// restoring the original value. MailChimp is not contacted.
+func RunSmokeCommand() int {
+	conf := ReadConfiguration()
+	if problems := conf.Validate(); len(problems) > 0 {
+		fmt.Println(FormatProblems(problems))
+		return 1
+	}
+	if conf.SmokeTestUrl == "" {
+		fmt.Println("SmokeTestUrl must be set to run the smoke test")
+		return 1
+	}
+
+	ConfigureHttpTransport(conf)
+
+	ctx := context.Background()
+	result := NewResult()
+	service := NewLinkService(conf)
+	linkId := LinkTargets(conf)[0].LinkId
+	passed := true
+
+	original, err := service.GetURL(ctx, conf, result, linkId)
+	if err != nil {
+		fmt.Printf("Reading %s link failed: %s\n", service.Name(), err)
+		return 1
+	}
+	fmt.Printf("Current %s link: %s\n", service.Name(), original)
+
+	if err := service.UpdateURL(ctx, conf, result, linkId, conf.SmokeTestUrl); err != nil {
+		fmt.Printf("\tUpdate to %s failed: %s\n", conf.SmokeTestUrl, err)
+		passed = false
+	} else if err := VerifyLinkUpdate(ctx, conf, result, service, linkId, conf.SmokeTestUrl); err != nil {
+		fmt.Printf("\tUpdated to %s but verification failed: %s\n", conf.SmokeTestUrl, err)
+		passed = false
+	} else {
+		fmt.Printf("\tUpdated to %s and verified\n", conf.SmokeTestUrl)
+	}
+
+	// Always attempt the restore, even if the smoke update looked like it failed
+	if err := service.UpdateURL(ctx, conf, result, linkId, original); err != nil {
+		fmt.Printf("\tRestore to %s failed, fix the link manually: %s\n", original, err)
+		passed = false
+	} else if err := VerifyLinkUpdate(ctx, conf, result, service, linkId, original); err != nil {
+		fmt.Printf("\tRestore to %s could not be verified, check the link manually: %s\n", original, err)
+		passed = false
+	} else {
+		fmt.Printf("\tRestored to %s and verified\n", original)
+	}
+
+	fmt.Println(result.RetryMetrics())
+
+	if !passed {
+		fmt.Println("Smoke test FAILED")
+		return 1
+	}
+
+	fmt.Println("Smoke test OK")
+	return 0
+}
+
+// RunConfigCommand handles the config subcommands, currently only show, which prints
+// the effective configuration with secrets redacted and where each value came from:
+// env, file (.env), vault or default. It returns the process exit code.
+func RunConfigCommand(args []string) int {
+	if len(args) != 1 || args[0] != "show" {
+		fmt.Println("Usage: config show")
+		return 1
+	}
+
+	conf := ReadConfiguration()
+
+	value := reflect.ValueOf(conf)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fmt.Printf("%s = %s (%s)\n", field.Name, configDisplayValue(field.Name, value.Field(i)), conf.Source(field.Name))
+	}
+
+	return 0
+}
+
+// configSecretMarkers identify keys whose values are never printed. Ping and
+// screenshot service URLs usually carry their credential in the URL itself, and a
+// Vault AppRole role id is half of a login.
+var configSecretMarkers = []string{"Password", "ApiKey", "Secret", "Token", "RoleId", "WebhookUrl", "PingUrl", "ScreenshotServiceUrl"}
+
+func configDisplayValue(key string, value reflect.Value) string {
+	if value.Kind() == reflect.Ptr && value.IsNil() {
+		return `""`
+	}
+
+	display := fmt.Sprintf("%v", value.Interface())
+	for _, marker := range configSecretMarkers {
+		if strings.Contains(key, marker) {
+			if display == "" {
+				return `""`
+			}
+			return "[REDACTED]"
+		}
+	}
+
+	return fmt.Sprintf("%q", display)
+}
+
+func FormatProblems(problems []error) string {
+	lines := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		lines = append(lines, "\t- "+problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,965 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"golang.org/x/text/encoding/htmlindex"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Configuration struct {
+	SmtpHost                    string
+	SmtpPort                    string
+	SmtpSecurity                string
+	SmtpUsername                string
+	SmtpPassword                string
+	SmtpFromEmail               string
+	SendEmailTo                 string
+	SendEmailCc                 string
+	SendEmailBcc                string
+	EmailCharset                string
+	SmtpLocalName               string
+	SmtpRetryCount              int
+	SmtpRetryDelay              time.Duration
+	NotifyRecipients            map[string]NotifyLevel
+	NotifyOnTransition          bool
+	NotifyLanguage              string
+	MaxNotificationsPerHour     int
+	MaxNotifyRetries            int
+	NotifyTimeout               time.Duration
+	NotifyTotalTimeout          time.Duration
+	DeadLetterDir               string
+	MailChimpServerPrefix       string
+	MailChimpApiKey             string
+	MailChimpApiVersion         string
+	MailChimpBaseUrl            string
+	MailChimpUrlField           string
+	NotifyReportLink            bool
+	MailChimpReportUrlTemplate  string
+	MailChimpFetchCount         int
+	MailChimpListId             string
+	MailChimpListName           string
+	MailChimpOffset             int
+	VerifyLatestSendTime        bool
+	FailOnAmbiguousLatest       bool
+	ConfirmReads                bool
+	ConfirmReadDelay            time.Duration
+	MailChimpSinceSendTime      string
+	TrackHistory                bool
+	HistoryMaxEntries           int
+	ArchiveUrlFallback          bool
+	VerifyArchiveReachable      bool
+	ArchiveCheckTimeout         time.Duration
+	ArchiveCheckTimeoutPolicy   string
+	ArchiveDomainRewrite        map[string]string
+	StrictResponseValidation    bool
+	ClockSkewToleranceSeconds   int
+	ExcludeTestCampaigns        bool
+	TestCampaignPattern         *regexp.Regexp
+	MinEmailsSent               int
+	LinkProvider                string
+	S3Bucket                    string
+	S3Key                       string
+	S3PreviewKey                string
+	S3Region                    string
+	S3Format                    string
+	WebhookUrl                  string
+	WebhookPreviewUrl           string
+	WebhookBatchUrl             string
+	WebhookSigningSecret        string
+	WebhookSignatureHeader      string
+	ResultWebhookUrl            string
+	ResultWebhookSigningSecret  string
+	UrlDayLinkId                string
+	UrlDayPreviewLinkId         string
+	MailChimpPreviewUrlField    string
+	UrlTransformTemplate        string
+	LinkTransformTemplates      map[string]string
+	UrlDayApiKey                string
+	UrlDayBaseUrl               string
+	UrlDayRateLimitThreshold    int
+	UrlDayOkStatuses            StatusSet
+	UrlDayRequireJson           bool
+	TrackShortUrl               bool
+	UrlDayConflictRetries       int
+	UrlDayConflictDelay         time.Duration
+	RecreateMissingLink         bool
+	RecreateLinkAlias           string
+	LinkCacheTtl                time.Duration
+	MailChimpOkStatuses         StatusSet
+	VerifyUpdate                bool
+	VerifyReadRetries           int
+	VerifyReadDelay             time.Duration
+	RetryCount                  int
+	RetryDelaySeconds           int
+	RetryMaxDelay               time.Duration
+	RetryAttemptTimeout         time.Duration
+	RetryMaxElapsed             time.Duration
+	HardTimeoutSeconds          int
+	MaxIdleConns                int
+	MaxIdleConnsPerHost         int
+	IdleConnTimeout             time.Duration
+	UpdateSchedule              *UpdateSchedule
+	DaemonInterval              time.Duration
+	ScheduleAlign               time.Duration
+	ScheduleJitter              time.Duration
+	HealthAddr                  string
+	DebugToken                  string
+	ReadOnly                    bool
+	AttachCampaignJson          bool
+	AttachScreenshots           bool
+	ScreenshotServiceUrl        string
+	ScreenshotTimeout           time.Duration
+	StateBackend                string
+	StateFile                   string
+	RedisAddr                   string
+	RedisPassword               string
+	RedisDb                     int
+	RedisKeyPrefix              string
+	RedisStateTtlSeconds        int
+	IgnoreSchemeForSameCampaign bool
+	CompareHostCaseInsensitive  bool
+	ComparePathCaseInsensitive  bool
+	IgnoreTrackingParams        bool
+	StripTrackingParams         bool
+	CompareResolvedRedirect     bool
+	CompareMode                 string
+	FirstRunMode                string
+	SmokeTestUrl                string
+	SuccessPingUrl              string
+	FailurePingUrl              string
+	InstanceName                string
+	CorrelationHeaderName       string
+	OtelEndpoint                string
+	SlackWebhookUrl             string
+	SlackBlockKit               bool
+	SlackBlockTemplate          string
+	NotifyTemplateUpdated       string
+	NotifyTemplateNoop          string
+	NotifyTemplatePartial       string
+	NotifyTemplateError         string
+	LogLevel                    string
+	LogOutput                   string
+	Features                    map[string]bool
+	TrimConfigValues            bool
+	ConfigSource                string
+	VaultAddr                   string
+	VaultToken                  string
+	VaultRoleId                 string
+	VaultSecretId               string
+	VaultPath                   string
+	VaultNamespace              string
+
+	// problems found while parsing values, reported by Validate
+	problems []error
+	// sources records where each key was read from, see Source
+	sources map[string]string
+	// trimmed lists the keys whose value had surrounding whitespace removed
+	trimmed []string
+	// smtpPortInferred is set when SmtpPort was left empty and taken from SmtpSecurity
+	smtpPortInferred bool
+}
+
+func ReadConfiguration() Configuration {
+	conf := Configuration{}
+
+	// Reads from the environment, loading a .env file in the directory you are executing
+	// from first if there is one. The following keys are used:
+	/*
+		SmtpHost
+		SmtpPort (optional, defaults to 587 for SmtpSecurity=starttls, 465 for tls and 25 for none)
+		SmtpSecurity (optional, starttls to upgrade the connection when the server offers it, tls to connect
+			over TLS from the start or none for plain SMTP, defaults to starttls)
+		SmtpUsername
+		SmtpPassword
+		SmtpFromEmail
+		SendEmailTo (comma separated)
+		SendEmailCc, SendEmailBcc (optional, comma separated, an address is only sent one copy, To first)
+		SmtpLocalName (optional, hostname announced in HELO/EHLO, defaults to localhost)
+		SmtpRetryCount (optional, times a send failing with a dropped connection or 4xx reply is retried,
+			authentication failures never are, defaults to 1)
+		SmtpRetryDelay (optional, duration before the first SMTP retry, doubling on each retry, defaults to 1s)
+		EmailCharset (optional, charset the subject and body are encoded in, defaults to UTF-8)
+		NotifyRecipients (optional, comma separated target:level where target is an address or slack and
+			level is error, change or all; anything not listed gets all)
+		NotifyOnTransition (optional, only notify on failure, recovery or change rather than every run, defaults to false)
+		MaxNotificationsPerHour (optional, per channel, notifications over it are dropped and counted in the next one,
+			defaults to 0 for no limit)
+		MaxNotifyRetries (optional, times a failed notification is resent before giving up, defaults to 0)
+		NotifyTimeout (optional, duration each notifier gets including its retries, defaults to 1m)
+		NotifyTotalTimeout (optional, duration all notifiers together get, they are sent concurrently, defaults to 2m)
+		DeadLetterDir (optional, directory undelivered notifications are written to for notify-replay)
+		NotifyLanguage (optional, language of the notification texts in messages/, e.g. en, es or fr, defaults to en)
+		MailChimpServerPrefix
+		MailChimpApiKey
+		MailChimpApiVersion (optional, API version in the base path, defaults to 3.0)
+		MailChimpBaseUrl (optional, e.g. a versioned proxy, defaults to https://<MailChimpServerPrefix>.api.mailchimp.com)
+		MailChimpUrlField (optional, archive_url or long_archive_url, defaults to long_archive_url)
+		NotifyReportLink (optional, include a link to the campaign report in MailChimp in notifications, defaults to false)
+		MailChimpReportUrlTemplate (optional, report link with {{server_prefix}} and {{web_id}}, defaults to
+			https://{{server_prefix}}.admin.mailchimp.com/reports/summary?id={{web_id}})
+		MailChimpFetchCount (optional, number of recent campaigns to fetch, defaults to 1)
+		MailChimpListId (optional, only consider campaigns sent to this audience)
+		MailChimpListName (optional, as MailChimpListId but by audience name, which must match exactly one)
+		MailChimpOffset (optional, mirror the campaign this many places before the newest candidate, e.g. 1 for
+			the second latest, skipping the update when fewer were fetched, defaults to 0)
+		VerifyLatestSendTime (optional, pick the newest fetched campaign by send_time, defaults to false)
+		FailOnAmbiguousLatest (optional, fail and notify instead of picking one when several campaigns share the
+			newest send_time, defaults to false)
+		TrackHistory (optional, keep a history of every sent campaign in the state, defaults to false)
+		HistoryMaxEntries (optional, most recent history entries kept, 0 for all, defaults to 500)
+		ConfirmReads (optional, only update when two reads of the latest campaign agree, defaults to false)
+		ConfirmReadDelay (optional, duration between the two ConfirmReads reads, defaults to 5s)
+		MailChimpSinceSendTime (optional, only consider campaigns sent after this ISO 8601 timestamp)
+		ArchiveUrlFallback (optional, fetch the full campaign when its archive URL is missing, defaults to false)
+		VerifyArchiveReachable (optional, check the campaign archive responds before pointing a link at it and
+			skip the update if it does not, defaults to false)
+		ArchiveCheckTimeout (optional, duration the reachability check gets including retries, defaults to 5s)
+		ArchiveCheckTimeoutPolicy (optional, skip to update anyway when the check times out, noting the archive
+			as unverified, or fail, defaults to skip)
+		ArchiveDomainRewrite (optional, comma separated from=to hosts, e.g. us21.campaign-archive.com=archive.mybrand.com)
+		StrictResponseValidation (optional, fail when the campaign is missing its id or URL fields, defaults to false)
+		ClockSkewToleranceSeconds (optional, how far in the future a send_time may be before it is ignored, defaults to 300)
+		ExcludeTestCampaigns (optional, skip campaigns whose title or subject matches TestCampaignPattern, defaults to false)
+		TestCampaignPattern (optional, regular expression, defaults to (?i)\btest\b)
+		MinEmailsSent (optional, skip campaigns sent to fewer recipients and mirror the newest earlier one that
+			is not, notifying at change level the first time a campaign is skipped, defaults to 0)
+		LinkProvider (optional, urlday, s3 or webhook, defaults to urlday)
+		S3Bucket, S3Key (required for LinkProvider=s3, object the latest URL is written to)
+		S3PreviewKey (optional, object kept in sync with MailChimpPreviewUrlField)
+		S3Region (optional, defaults to the AWS configuration)
+		S3Format (optional, json or text, defaults to json)
+		WebhookUrl (required for LinkProvider=webhook, endpoint answering GET and PUT with {"url": "..."})
+		WebhookPreviewUrl (optional, endpoint kept in sync with MailChimpPreviewUrlField)
+		WebhookBatchUrl (optional, endpoint accepting a single PUT of {"links": [{"id": "...", "url": "..."}]},
+			where id is the link endpoint, when several links are updated in a run)
+		WebhookSigningSecret (optional, sign updates with an HMAC-SHA256 of the body)
+		WebhookSignatureHeader (optional, header the sha256=<hex> signature is sent in, defaults to X-Signature-256)
+		ResultWebhookUrl (optional, endpoint the run outcome and full result are POSTed to as JSON after every run)
+		ResultWebhookSigningSecret (optional, sign result posts like WebhookSigningSecret, in WebhookSignatureHeader)
+		UrlDayLinkId (required for LinkProvider=urlday)
+		UrlDayPreviewLinkId (optional, a second link kept in sync with MailChimpPreviewUrlField)
+		MailChimpPreviewUrlField (optional, defaults to archive_url)
+		UrlTransformTemplate (optional, template the campaign URL is put through before it is compared and
+			pushed, e.g. {{url}}?utm_source=website, see ApplyUrlTransform for the placeholders)
+		LinkTransformTemplates (optional, ";" separated link=template pairs overriding UrlTransformTemplate per
+			link, where link is a link id or primary/preview)
+		UrlDayApiKey (required for LinkProvider=urlday)
+		UrlDayBaseUrl (optional, e.g. a proxy or test server, defaults to https://www.urlday.com/api/v1)
+		UrlDayOkStatuses (optional, HTTP statuses treated as success, e.g. "200-299,304", defaults to 200-299)
+		MailChimpOkStatuses (optional, as UrlDayOkStatuses)
+		UrlDayRequireJson (optional, reject UrlDay responses without a JSON Content-Type, defaults to true)
+		TrackShortUrl (optional, warn when a link's short_url changes between runs, defaults to false)
+		UrlDayConflictRetries (optional, times an update answered with 409 Conflict is retried, defaults to 3)
+		UrlDayConflictDelay (optional, duration to wait before retrying a conflicting update, defaults to 5s)
+		RecreateMissingLink (optional, create the UrlDay link again with the campaign URL when it returns 404,
+			remembering the new id in the state, defaults to false)
+		RecreateLinkAlias (optional, alias given to a recreated link)
+		LinkCacheTtl (optional, duration a link URL read is kept in the state and reused instead of reading the
+			link again, an updated link is always re-read, defaults to 0 for no caching)
+		UrlDayRateLimitThreshold (optional, wait for the rate-limit reset at or below this many remaining requests, defaults to 1)
+		VerifyUpdate (optional, defaults to true)
+		VerifyReadRetries (optional, extra read-backs while UrlDay still shows the old URL, defaults to 2)
+		VerifyReadDelay (optional, duration between read-backs such as 2s, defaults to 2s)
+		RetryCount (optional, defaults to 2)
+		RetryDelaySeconds (optional, first retry delay which doubles on each retry, defaults to 1)
+		RetryMaxDelay (optional, cap on the retry delay such as 30s, defaults to 30s)
+		RetryAttemptTimeout (optional, time limit for each attempt, defaults to 30s)
+		RetryMaxElapsed (optional, total time budget for a request including retries, defaults to 2m)
+		HardTimeoutSeconds (optional, abort a run taking longer than this, and exit if it does not stop,
+			as a last resort beyond the other timeouts, defaults to 0 for no limit)
+		MaxIdleConns (optional, idle connections kept across all hosts, defaults to 100)
+		MaxIdleConnsPerHost (optional, idle connections kept per host, defaults to 10)
+		IdleConnTimeout (optional, duration an idle connection is kept open, defaults to 90s)
+		UpdateSchedule (optional, e.g. "Mon-Fri 06:00-12:00", defaults to any time)
+		DaemonInterval (optional, duration between runs of the daemon command, defaults to 1h)
+		ScheduleAlign (optional, run the daemon on wall-clock multiples of this duration since local midnight
+			instead of every DaemonInterval, e.g. 1h for the top of every hour, defaults to 0 for no alignment)
+		ScheduleJitter (optional, random delay of up to this duration added to every daemon run, defaults to 0)
+		HealthAddr (optional, address such as :8080 the daemon serves /healthz and /debug on)
+		DebugToken (optional, bearer token required by /debug, which exposes the redacted configuration,
+			the last result, the retry and failure counts per stage and the caches)
+		ReadOnly (optional, never update any link, only report what would change, defaults to false)
+		AttachCampaignJson (optional, defaults to false)
+		AttachScreenshots (optional, attach screenshots of the old and new page of every updated link,
+			defaults to false)
+		ScreenshotServiceUrl (required for AttachScreenshots, screenshot service returning an image,
+			with {{url}} for the query escaped page URL, e.g. https://shots.example.com/capture?url={{url}})
+		ScreenshotTimeout (optional, duration each screenshot may take, defaults to 30s)
+		StateBackend (optional, file or redis, defaults to file)
+		StateFile (optional, defaults to mailchimptowebsite-state.json)
+		RedisAddr (required for StateBackend=redis, host:port)
+		RedisPassword (optional)
+		RedisDb (optional, defaults to 0)
+		RedisKeyPrefix (optional, defaults to mailchimptowebsite:)
+		RedisStateTtlSeconds (optional, expire the stored state, defaults to 0 for never)
+		IgnoreSchemeForSameCampaign (optional, defaults to false)
+		CompareHostCaseInsensitive (optional, a host differing only in case is the same URL, defaults to true)
+		ComparePathCaseInsensitive (optional, a path differing only in case is the same URL, defaults to false)
+		IgnoreTrackingParams (optional, ignore utm_* and mc_* query parameters when comparing URLs, except those
+			added by the link transform template, defaults to true)
+		StripTrackingParams (optional, remove utm_* and mc_* query parameters from the campaign URL before
+			it is pushed, defaults to false)
+		CompareResolvedRedirect (optional, when the stored URL differs follow one redirect of it with a HEAD
+			request and compare where it points instead, defaults to false)
+		CompareMode (optional, url, web_id to ignore URL differences while the campaign web_id is unchanged,
+			or content-hash to only update when the archive page content changes, defaults to url)
+		FirstRunMode (optional, sync or baseline to only record state on the first run, defaults to sync)
+		SmokeTestUrl (optional, URL temporarily pushed to UrlDayLinkId by the smoke command)
+		SuccessPingUrl, FailurePingUrl (optional, GET at the end of each run for uptime monitors such as healthchecks.io)
+		InstanceName (optional, identifies this deployment in notifications, defaults to the hostname)
+		CorrelationHeaderName (optional, header carrying the run id on outbound requests, defaults to X-Request-Id, "none" to disable)
+		OtelEndpoint (optional, OTLP/HTTP collector as host:port or URL, enables tracing)
+		SlackWebhookUrl (optional, also notify this Slack incoming webhook)
+		SlackBlockKit (optional, send Slack notifications as Block Kit, defaults to false)
+		SlackBlockTemplate (optional, path to a Block Kit JSON template with {{placeholders}})
+		NotifyTemplateUpdated, NotifyTemplateNoop, NotifyTemplatePartial, NotifyTemplateError (optional, path to
+			the notification body template for that outcome, with the SlackBlockTemplate {{placeholders}}
+			plus {{outcome}} and {{run_id}})
+		LogLevel (optional, info or debug, defaults to info)
+		LogOutput (optional, stderr or journald to log to the systemd journal with priorities, defaults to stderr)
+		Features (optional, comma separated toggles for optional behaviour, name to enable and -name to
+			disable, see knownFeatures; everything is enabled by default)
+		TrimConfigValues (optional, strip surrounding whitespace and newlines from every value, which copy
+			and paste often leaves behind, defaults to true)
+		ConfigSource (optional, env or vault to also read keys from a Vault KV secret, which the environment
+			and .env override, defaults to env)
+		VaultAddr, VaultPath (required for ConfigSource=vault, e.g. https://vault:8200 and secret/data/mailchimptowebsite)
+		VaultToken (optional, token to read VaultPath with, otherwise VaultRoleId is used)
+		VaultRoleId, VaultSecretId (optional, AppRole credentials to log in with when VaultToken is not set)
+		VaultNamespace (optional, Vault Enterprise namespace)
+	*/
+	conf.sources = readConfigSources()
+
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatalf("Error loading .env file")
+	}
+
+	env := &envReader{}
+
+	if env.String("ConfigSource", "env") == "vault" {
+		if err := LoadVaultConfig(conf.sources); err != nil {
+			env.problems = append(env.problems, err)
+		}
+	}
+
+	conf.TrimConfigValues = env.Bool("TrimConfigValues", true)
+	if conf.TrimConfigValues {
+		conf.trimmed = trimConfigEnv()
+	}
+
+	conf.SmtpHost = os.Getenv("SmtpHost")
+	conf.SmtpPort = os.Getenv("SmtpPort")
+	conf.SmtpSecurity = env.String("SmtpSecurity", "starttls")
+	if conf.SmtpPort == "" {
+		conf.SmtpPort = DefaultSmtpPort(conf.SmtpSecurity)
+		conf.smtpPortInferred = conf.SmtpPort != ""
+	}
+	conf.SmtpUsername = os.Getenv("SmtpUsername")
+	conf.SmtpPassword = os.Getenv("SmtpPassword")
+	conf.SmtpFromEmail = os.Getenv("SmtpFromEmail")
+	conf.SendEmailTo = os.Getenv("SendEmailTo")
+	conf.SendEmailCc = os.Getenv("SendEmailCc")
+	conf.SendEmailBcc = os.Getenv("SendEmailBcc")
+	conf.SmtpLocalName = os.Getenv("SmtpLocalName")
+	conf.SmtpRetryCount = env.Int("SmtpRetryCount", 1)
+	conf.SmtpRetryDelay = env.Duration("SmtpRetryDelay", time.Second)
+	conf.EmailCharset = env.String("EmailCharset", "UTF-8")
+	conf.NotifyOnTransition = env.Bool("NotifyOnTransition", false)
+	conf.NotifyLanguage = env.String("NotifyLanguage", "en")
+	conf.MaxNotificationsPerHour = env.Int("MaxNotificationsPerHour", 0)
+	conf.MaxNotifyRetries = env.Int("MaxNotifyRetries", 0)
+	conf.NotifyTimeout = env.Duration("NotifyTimeout", time.Minute)
+	conf.NotifyTotalTimeout = env.Duration("NotifyTotalTimeout", 2*time.Minute)
+	conf.DeadLetterDir = os.Getenv("DeadLetterDir")
+	if value := os.Getenv("NotifyRecipients"); value != "" {
+		recipients, err := ParseNotifyRecipients(value)
+		if err != nil {
+			env.problems = append(env.problems, fmt.Errorf("invalid NotifyRecipients: %w", err))
+		}
+		conf.NotifyRecipients = recipients
+	}
+	conf.MailChimpServerPrefix = os.Getenv("MailChimpServerPrefix")
+	conf.MailChimpApiKey = os.Getenv("MailChimpApiKey")
+	conf.MailChimpApiVersion = strings.Trim(env.String("MailChimpApiVersion", "3.0"), "/")
+	conf.MailChimpBaseUrl = os.Getenv("MailChimpBaseUrl")
+	conf.MailChimpUrlField = env.String("MailChimpUrlField", "long_archive_url")
+	conf.NotifyReportLink = env.Bool("NotifyReportLink", false)
+	conf.MailChimpReportUrlTemplate = env.String("MailChimpReportUrlTemplate", "https://{{server_prefix}}.admin.mailchimp.com/reports/summary?id={{web_id}}")
+	conf.MailChimpFetchCount = env.Int("MailChimpFetchCount", 1)
+	conf.MailChimpOffset = env.Int("MailChimpOffset", 0)
+	conf.MailChimpListId = os.Getenv("MailChimpListId")
+	conf.MailChimpListName = os.Getenv("MailChimpListName")
+	conf.VerifyLatestSendTime = env.Bool("VerifyLatestSendTime", false)
+	conf.FailOnAmbiguousLatest = env.Bool("FailOnAmbiguousLatest", false)
+	conf.MailChimpSinceSendTime = os.Getenv("MailChimpSinceSendTime")
+	conf.TrackHistory = env.Bool("TrackHistory", false)
+	conf.HistoryMaxEntries = env.Int("HistoryMaxEntries", 500)
+	conf.ConfirmReads = env.Bool("ConfirmReads", false)
+	conf.ConfirmReadDelay = env.Duration("ConfirmReadDelay", 5*time.Second)
+	conf.ArchiveUrlFallback = env.Bool("ArchiveUrlFallback", false)
+	conf.VerifyArchiveReachable = env.Bool("VerifyArchiveReachable", false)
+	conf.ArchiveCheckTimeout = env.Duration("ArchiveCheckTimeout", 5*time.Second)
+	conf.ArchiveCheckTimeoutPolicy = env.String("ArchiveCheckTimeoutPolicy", "skip")
+	if value := os.Getenv("ArchiveDomainRewrite"); value != "" {
+		rewrites, err := ParseDomainRewrites(value)
+		if err != nil {
+			env.problems = append(env.problems, fmt.Errorf("invalid ArchiveDomainRewrite: %w", err))
+		}
+		conf.ArchiveDomainRewrite = rewrites
+	}
+	conf.StrictResponseValidation = env.Bool("StrictResponseValidation", false)
+	conf.ClockSkewToleranceSeconds = env.Int("ClockSkewToleranceSeconds", 300)
+	conf.ExcludeTestCampaigns = env.Bool("ExcludeTestCampaigns", false)
+	conf.TestCampaignPattern = env.Regexp("TestCampaignPattern", `(?i)\btest\b`)
+	conf.MinEmailsSent = env.Int("MinEmailsSent", 0)
+	conf.LinkProvider = env.String("LinkProvider", "urlday")
+	conf.S3Bucket = os.Getenv("S3Bucket")
+	conf.S3Key = os.Getenv("S3Key")
+	conf.S3PreviewKey = os.Getenv("S3PreviewKey")
+	conf.S3Region = os.Getenv("S3Region")
+	conf.S3Format = env.String("S3Format", "json")
+	conf.WebhookUrl = os.Getenv("WebhookUrl")
+	conf.WebhookPreviewUrl = os.Getenv("WebhookPreviewUrl")
+	conf.WebhookBatchUrl = os.Getenv("WebhookBatchUrl")
+	conf.WebhookSigningSecret = os.Getenv("WebhookSigningSecret")
+	conf.WebhookSignatureHeader = env.String("WebhookSignatureHeader", "X-Signature-256")
+	conf.ResultWebhookUrl = os.Getenv("ResultWebhookUrl")
+	conf.ResultWebhookSigningSecret = os.Getenv("ResultWebhookSigningSecret")
+	conf.UrlDayLinkId = os.Getenv("UrlDayLinkId")
+	conf.UrlDayPreviewLinkId = os.Getenv("UrlDayPreviewLinkId")
+	conf.MailChimpPreviewUrlField = env.String("MailChimpPreviewUrlField", "archive_url")
+	conf.UrlTransformTemplate = os.Getenv("UrlTransformTemplate")
+	if value := os.Getenv("LinkTransformTemplates"); value != "" {
+		transforms, err := ParseLinkTransforms(value)
+		if err != nil {
+			env.problems = append(env.problems, fmt.Errorf("invalid LinkTransformTemplates: %w", err))
+		}
+		conf.LinkTransformTemplates = transforms
+	}
+	conf.UrlDayApiKey = os.Getenv("UrlDayApiKey")
+	conf.UrlDayBaseUrl = os.Getenv("UrlDayBaseUrl")
+	conf.UrlDayRateLimitThreshold = env.Int("UrlDayRateLimitThreshold", 1)
+	conf.UrlDayOkStatuses = env.Statuses("UrlDayOkStatuses")
+	conf.MailChimpOkStatuses = env.Statuses("MailChimpOkStatuses")
+	conf.UrlDayRequireJson = env.Bool("UrlDayRequireJson", true)
+	conf.TrackShortUrl = env.Bool("TrackShortUrl", false)
+	conf.UrlDayConflictRetries = env.Int("UrlDayConflictRetries", 3)
+	conf.UrlDayConflictDelay = env.Duration("UrlDayConflictDelay", 5*time.Second)
+	conf.LinkCacheTtl = env.Duration("LinkCacheTtl", 0)
+	conf.RecreateMissingLink = env.Bool("RecreateMissingLink", false)
+	conf.RecreateLinkAlias = os.Getenv("RecreateLinkAlias")
+	conf.VerifyUpdate = env.Bool("VerifyUpdate", true)
+	conf.VerifyReadRetries = env.Int("VerifyReadRetries", 2)
+	conf.VerifyReadDelay = env.Duration("VerifyReadDelay", 2*time.Second)
+	conf.RetryCount = env.Int("RetryCount", 2)
+	conf.RetryDelaySeconds = env.Int("RetryDelaySeconds", 1)
+	conf.RetryMaxDelay = env.Duration("RetryMaxDelay", 30*time.Second)
+	conf.RetryAttemptTimeout = env.Duration("RetryAttemptTimeout", 30*time.Second)
+	conf.RetryMaxElapsed = env.Duration("RetryMaxElapsed", 2*time.Minute)
+	conf.HardTimeoutSeconds = env.Int("HardTimeoutSeconds", 0)
+	conf.MaxIdleConns = env.Int("MaxIdleConns", 100)
+	conf.MaxIdleConnsPerHost = env.Int("MaxIdleConnsPerHost", 10)
+	conf.IdleConnTimeout = env.Duration("IdleConnTimeout", 90*time.Second)
+	conf.DaemonInterval = env.Duration("DaemonInterval", time.Hour)
+	conf.ScheduleAlign = env.Duration("ScheduleAlign", 0)
+	conf.ScheduleJitter = env.Duration("ScheduleJitter", 0)
+	conf.HealthAddr = os.Getenv("HealthAddr")
+	conf.DebugToken = os.Getenv("DebugToken")
+	conf.AttachCampaignJson = env.Bool("AttachCampaignJson", false)
+	conf.AttachScreenshots = env.Bool("AttachScreenshots", false)
+	conf.ScreenshotServiceUrl = os.Getenv("ScreenshotServiceUrl")
+	conf.ScreenshotTimeout = env.Duration("ScreenshotTimeout", 30*time.Second)
+	conf.StateBackend = env.String("StateBackend", "file")
+	conf.StateFile = env.String("StateFile", "mailchimptowebsite-state.json")
+	conf.RedisAddr = os.Getenv("RedisAddr")
+	conf.RedisPassword = os.Getenv("RedisPassword")
+	conf.RedisDb = env.Int("RedisDb", 0)
+	conf.RedisKeyPrefix = env.String("RedisKeyPrefix", "mailchimptowebsite:")
+	conf.RedisStateTtlSeconds = env.Int("RedisStateTtlSeconds", 0)
+	conf.IgnoreSchemeForSameCampaign = env.Bool("IgnoreSchemeForSameCampaign", false)
+	conf.CompareHostCaseInsensitive = env.Bool("CompareHostCaseInsensitive", true)
+	conf.ComparePathCaseInsensitive = env.Bool("ComparePathCaseInsensitive", false)
+	conf.IgnoreTrackingParams = env.Bool("IgnoreTrackingParams", true)
+	conf.StripTrackingParams = env.Bool("StripTrackingParams", false)
+	conf.CompareResolvedRedirect = env.Bool("CompareResolvedRedirect", false)
+	conf.CompareMode = env.String("CompareMode", "url")
+	conf.FirstRunMode = env.String("FirstRunMode", "sync")
+	conf.SuccessPingUrl = os.Getenv("SuccessPingUrl")
+	conf.FailurePingUrl = os.Getenv("FailurePingUrl")
+	conf.SmokeTestUrl = os.Getenv("SmokeTestUrl")
+	conf.InstanceName = env.String("InstanceName", DefaultInstanceName())
+	conf.CorrelationHeaderName = env.String("CorrelationHeaderName", "X-Request-Id")
+	if strings.EqualFold(conf.CorrelationHeaderName, "none") {
+		conf.CorrelationHeaderName = ""
+	}
+	conf.OtelEndpoint = os.Getenv("OtelEndpoint")
+	conf.SlackWebhookUrl = os.Getenv("SlackWebhookUrl")
+	conf.SlackBlockKit = env.Bool("SlackBlockKit", false)
+	conf.SlackBlockTemplate = os.Getenv("SlackBlockTemplate")
+	conf.NotifyTemplateUpdated = os.Getenv("NotifyTemplateUpdated")
+	conf.NotifyTemplateNoop = os.Getenv("NotifyTemplateNoop")
+	conf.NotifyTemplatePartial = os.Getenv("NotifyTemplatePartial")
+	conf.NotifyTemplateError = os.Getenv("NotifyTemplateError")
+	conf.LogLevel = env.String("LogLevel", "info")
+	conf.LogOutput = env.String("LogOutput", "stderr")
+	conf.Features = env.Features("Features")
+	conf.ConfigSource = env.String("ConfigSource", "env")
+	conf.VaultAddr = os.Getenv("VaultAddr")
+	conf.VaultToken = os.Getenv("VaultToken")
+	conf.VaultRoleId = os.Getenv("VaultRoleId")
+	conf.VaultSecretId = os.Getenv("VaultSecretId")
+	conf.VaultPath = os.Getenv("VaultPath")
+	conf.VaultNamespace = os.Getenv("VaultNamespace")
+
+	conf.ReadOnly = env.Bool("ReadOnly", false)
+	if value := os.Getenv("UpdateSchedule"); value != "" {
+		schedule, err := ParseUpdateSchedule(value)
+		if err != nil {
+			env.problems = append(env.problems, fmt.Errorf("invalid UpdateSchedule: %w", err))
+		}
+		conf.UpdateSchedule = schedule
+	}
+
+	conf.problems = env.problems
+
+	return conf
+}
+
+// Validate checks the configuration without contacting any service and returns
+// every problem found, or nil if it is usable.
+func (conf Configuration) Validate() []error {
+	problems := append([]error{}, conf.problems...)
+
+	type requiredKey struct{ key, value string }
+
+	required := []requiredKey{
+		{"SmtpHost", conf.SmtpHost},
+		{"SmtpPort", conf.SmtpPort},
+		{"SmtpPassword", conf.SmtpPassword},
+		{"SmtpFromEmail", conf.SmtpFromEmail},
+		{"MailChimpServerPrefix", conf.MailChimpServerPrefix},
+		{"MailChimpApiKey", conf.MailChimpApiKey},
+	}
+	if provider := FindLinkProvider(conf.LinkProvider); provider != nil {
+		for _, key := range provider.RequiredKeys {
+			required = append(required, requiredKey{key, ConfigString(conf, key)})
+		}
+	} else {
+		problems = append(problems, fmt.Errorf("invalid LinkProvider %q, expected one of %s", conf.LinkProvider, LinkProviderNames()))
+	}
+	if conf.LinkProvider == "s3" && conf.S3Format != "json" && conf.S3Format != "text" {
+		problems = append(problems, fmt.Errorf("invalid S3Format %q, expected json or text", conf.S3Format))
+	}
+	for _, r := range required {
+		if r.value == "" {
+			problems = append(problems, fmt.Errorf("%s is required", r.key))
+		}
+	}
+
+	if len(EmailRecipients(conf, NotifyLevelError)) == 0 && len(EmailRecipients(conf, NotifyLevelChange)) == 0 {
+		problems = append(problems, errors.New("SendEmailTo or an address in NotifyRecipients is required"))
+	}
+	if _, err := strconv.Atoi(conf.SmtpPort); conf.SmtpPort != "" && err != nil {
+		problems = append(problems, fmt.Errorf("SmtpPort must be a number, got %q", conf.SmtpPort))
+	}
+	if DefaultSmtpPort(conf.SmtpSecurity) == "" {
+		problems = append(problems, fmt.Errorf("invalid SmtpSecurity %q, expected starttls, tls or none", conf.SmtpSecurity))
+	}
+	if conf.SmtpRetryCount < 0 || conf.SmtpRetryDelay < 0 {
+		problems = append(problems, errors.New("SmtpRetryCount and SmtpRetryDelay must not be negative"))
+	}
+	if conf.MaxNotificationsPerHour < 0 || conf.MaxNotifyRetries < 0 || conf.NotifyTimeout < 0 || conf.NotifyTotalTimeout < 0 {
+		problems = append(problems, errors.New("MaxNotificationsPerHour, MaxNotifyRetries, NotifyTimeout and NotifyTotalTimeout must not be negative"))
+	}
+	if !HasMessages(conf.NotifyLanguage) {
+		problems = append(problems, fmt.Errorf("unsupported NotifyLanguage %q", conf.NotifyLanguage))
+	}
+	if _, err := htmlindex.Get(conf.EmailCharset); err != nil {
+		problems = append(problems, fmt.Errorf("unsupported EmailCharset %q", conf.EmailCharset))
+	}
+	if !IsMailChimpUrlField(conf.MailChimpUrlField) {
+		problems = append(problems, fmt.Errorf("invalid MailChimpUrlField %q, expected archive_url or long_archive_url", conf.MailChimpUrlField))
+	}
+	if !IsMailChimpUrlField(conf.MailChimpPreviewUrlField) {
+		problems = append(problems, fmt.Errorf("invalid MailChimpPreviewUrlField %q, expected archive_url or long_archive_url", conf.MailChimpPreviewUrlField))
+	}
+	if parsed, err := url.Parse(conf.MailChimpBaseUrl); conf.MailChimpBaseUrl != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+		problems = append(problems, fmt.Errorf("MailChimpBaseUrl must be an absolute URL, got %q", conf.MailChimpBaseUrl))
+	}
+	if parsed, err := url.Parse(conf.UrlDayBaseUrl); conf.UrlDayBaseUrl != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+		problems = append(problems, fmt.Errorf("UrlDayBaseUrl must be an absolute URL, got %q", conf.UrlDayBaseUrl))
+	}
+	if parsed, err := url.Parse(conf.ResultWebhookUrl); conf.ResultWebhookUrl != "" && (err != nil || parsed.Scheme == "" || parsed.Host == "") {
+		problems = append(problems, fmt.Errorf("ResultWebhookUrl must be an absolute URL, got %q", conf.ResultWebhookUrl))
+	}
+	if conf.AttachScreenshots {
+		if parsed, err := url.Parse(conf.ScreenshotServiceUrl); err != nil || parsed.Scheme == "" || parsed.Host == "" || !strings.Contains(conf.ScreenshotServiceUrl, "{{url}}") {
+			problems = append(problems, errors.New("ScreenshotServiceUrl must be an absolute URL containing {{url}} for AttachScreenshots"))
+		}
+	}
+	if conf.ScreenshotTimeout < 0 {
+		problems = append(problems, errors.New("ScreenshotTimeout must not be negative"))
+	}
+	if conf.MailChimpFetchCount < 1 || conf.MailChimpFetchCount > 1000 {
+		problems = append(problems, errors.New("MailChimpFetchCount must be between 1 and 1000"))
+	}
+	if _, err := time.Parse(time.RFC3339, conf.MailChimpSinceSendTime); conf.MailChimpSinceSendTime != "" && err != nil {
+		problems = append(problems, fmt.Errorf("MailChimpSinceSendTime must be an ISO 8601 timestamp like 2024-01-31T00:00:00+00:00, got %q", conf.MailChimpSinceSendTime))
+	}
+	if conf.ArchiveCheckTimeout < 0 {
+		problems = append(problems, errors.New("ArchiveCheckTimeout must not be negative"))
+	}
+	if conf.ArchiveCheckTimeoutPolicy != "skip" && conf.ArchiveCheckTimeoutPolicy != "fail" {
+		problems = append(problems, fmt.Errorf("invalid ArchiveCheckTimeoutPolicy %q, expected skip or fail", conf.ArchiveCheckTimeoutPolicy))
+	}
+	if conf.ConfirmReadDelay < 0 || conf.HistoryMaxEntries < 0 {
+		problems = append(problems, errors.New("ConfirmReadDelay and HistoryMaxEntries must not be negative"))
+	}
+	if conf.MailChimpListId != "" && conf.MailChimpListName != "" {
+		problems = append(problems, errors.New("set only one of MailChimpListId and MailChimpListName"))
+	}
+	if conf.MailChimpOffset < 0 {
+		problems = append(problems, errors.New("MailChimpOffset must not be negative"))
+	}
+	if conf.MailChimpOffset >= conf.MailChimpFetchCount && conf.MailChimpOffset > 0 {
+		problems = append(problems, errors.New("MailChimpOffset needs MailChimpFetchCount above it to reach that campaign"))
+	}
+	if conf.VerifyLatestSendTime && conf.MailChimpFetchCount == 1 {
+		problems = append(problems, errors.New("VerifyLatestSendTime needs MailChimpFetchCount above 1 to compare campaigns"))
+	}
+	if conf.FailOnAmbiguousLatest && conf.MailChimpFetchCount == 1 {
+		problems = append(problems, errors.New("FailOnAmbiguousLatest needs MailChimpFetchCount above 1 to compare campaigns"))
+	}
+	if conf.ClockSkewToleranceSeconds < 0 {
+		problems = append(problems, errors.New("ClockSkewToleranceSeconds must not be negative"))
+	}
+	if conf.ExcludeTestCampaigns && conf.MailChimpFetchCount == 1 {
+		problems = append(problems, errors.New("ExcludeTestCampaigns needs MailChimpFetchCount above 1 to fall back to an earlier campaign"))
+	}
+	if conf.MinEmailsSent < 0 {
+		problems = append(problems, errors.New("MinEmailsSent must not be negative"))
+	}
+	if conf.MinEmailsSent > 0 && conf.MailChimpFetchCount == 1 {
+		problems = append(problems, errors.New("MinEmailsSent needs MailChimpFetchCount above 1 to fall back to an earlier campaign"))
+	}
+	if conf.UrlTransformTemplate != "" && !strings.Contains(conf.UrlTransformTemplate, "{{url}}") && !strings.Contains(conf.UrlTransformTemplate, "{{url_escaped}}") {
+		problems = append(problems, errors.New("UrlTransformTemplate must contain {{url}} or {{url_escaped}}"))
+	}
+	if conf.UrlDayPreviewLinkId != "" && conf.UrlDayPreviewLinkId == conf.UrlDayLinkId {
+		problems = append(problems, errors.New("UrlDayPreviewLinkId must differ from UrlDayLinkId"))
+	}
+	if conf.UrlDayConflictRetries < 0 || conf.UrlDayConflictDelay < 0 || conf.LinkCacheTtl < 0 {
+		problems = append(problems, errors.New("UrlDayConflictRetries, UrlDayConflictDelay and LinkCacheTtl must not be negative"))
+	}
+	if conf.VerifyReadRetries < 0 {
+		problems = append(problems, errors.New("VerifyReadRetries must not be negative"))
+	}
+	if conf.VerifyReadDelay < 0 {
+		problems = append(problems, errors.New("VerifyReadDelay must not be negative"))
+	}
+	if conf.MaxIdleConns < 0 || conf.MaxIdleConnsPerHost < 0 || conf.IdleConnTimeout < 0 {
+		problems = append(problems, errors.New("MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeout must not be negative"))
+	}
+	if conf.DaemonInterval <= 0 {
+		problems = append(problems, errors.New("DaemonInterval must be positive"))
+	}
+	if conf.ScheduleAlign < 0 || conf.ScheduleJitter < 0 {
+		problems = append(problems, errors.New("ScheduleAlign and ScheduleJitter must not be negative"))
+	}
+	if conf.ScheduleAlign > 24*time.Hour {
+		problems = append(problems, errors.New("ScheduleAlign must be at most 24h, it aligns within the day"))
+	}
+	if conf.RetryCount < 0 {
+		problems = append(problems, errors.New("RetryCount must not be negative"))
+	}
+	if conf.RetryDelaySeconds < 0 {
+		problems = append(problems, errors.New("RetryDelaySeconds must not be negative"))
+	}
+	if conf.RetryMaxDelay < 0 || conf.RetryAttemptTimeout < 0 || conf.RetryMaxElapsed < 0 {
+		problems = append(problems, errors.New("RetryMaxDelay, RetryAttemptTimeout and RetryMaxElapsed must not be negative"))
+	}
+	if conf.HardTimeoutSeconds < 0 {
+		problems = append(problems, errors.New("HardTimeoutSeconds must not be negative"))
+	}
+	if conf.CompareMode != "url" && conf.CompareMode != "web_id" && conf.CompareMode != "content-hash" {
+		problems = append(problems, fmt.Errorf("invalid CompareMode %q, expected url, web_id or content-hash", conf.CompareMode))
+	}
+	if conf.ConfigSource != "env" && conf.ConfigSource != "vault" {
+		problems = append(problems, fmt.Errorf("invalid ConfigSource %q, expected env or vault", conf.ConfigSource))
+	}
+	if conf.LogLevel != "info" && conf.LogLevel != "debug" {
+		problems = append(problems, fmt.Errorf("invalid LogLevel %q, expected info or debug", conf.LogLevel))
+	}
+	if conf.LogOutput != "stderr" && conf.LogOutput != "journald" {
+		problems = append(problems, fmt.Errorf("invalid LogOutput %q, expected stderr or journald", conf.LogOutput))
+	}
+	if conf.FirstRunMode != "sync" && conf.FirstRunMode != "baseline" {
+		problems = append(problems, fmt.Errorf("invalid FirstRunMode %q, expected sync or baseline", conf.FirstRunMode))
+	}
+
+	switch conf.StateBackend {
+	case "file":
+		if conf.StateFile == "" {
+			problems = append(problems, errors.New("StateFile must not be empty"))
+		}
+	case "redis":
+		if conf.RedisAddr == "" {
+			problems = append(problems, errors.New("RedisAddr is required for StateBackend=redis"))
+		}
+		if conf.RedisStateTtlSeconds < 0 {
+			problems = append(problems, errors.New("RedisStateTtlSeconds must not be negative"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("invalid StateBackend %q, expected file or redis", conf.StateBackend))
+	}
+
+	return problems
+}
+
+// ParseDomainRewrites parses comma separated from=to host pairs
+func ParseDomainRewrites(value string) (map[string]string, error) {
+	rewrites := map[string]string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		from, to, found := strings.Cut(item, "=")
+		from, to = strings.ToLower(strings.TrimSpace(from)), strings.TrimSpace(to)
+		if !found || from == "" || to == "" {
+			return nil, fmt.Errorf("rewrite %q must be from=to", item)
+		}
+		if strings.ContainsAny(to, "/?#@ ") {
+			return nil, fmt.Errorf("rewrite %q must map to a host only", item)
+		}
+
+		rewrites[from] = to
+	}
+
+	return rewrites, nil
+}
+
+// knownFeatures are the behaviours Features can toggle, with what they control
+var knownFeatures = map[string]string{
+	"retry":  "retrying failed requests, see RetryCount",
+	"verify": "reading links back after an update, see VerifyUpdate",
+	"cache":  "reusing link URLs read within LinkCacheTtl",
+}
+
+// FeatureEnabled reports whether a knownFeatures behaviour is on, which it is
+// unless Features disables it
+func (conf Configuration) FeatureEnabled(name string) bool {
+	enabled, ok := conf.Features[name]
+	return !ok || enabled
+}
+
+// ConfigKeys lists every configuration key, as fields are named after their key
+func ConfigKeys() []string {
+	fields := reflect.TypeOf(Configuration{})
+	keys := make([]string, 0, fields.NumField())
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).IsExported() {
+			keys = append(keys, fields.Field(i).Name)
+		}
+	}
+	return keys
+}
+
+// readConfigSources must run before .env is loaded, as it never overrides the
+// environment and anything set by then came from the environment itself
+func readConfigSources() map[string]string {
+	fileValues, _ := godotenv.Read()
+
+	sources := map[string]string{}
+	for _, key := range ConfigKeys() {
+		if _, ok := os.LookupEnv(key); ok {
+			sources[key] = "env"
+		} else if _, ok := fileValues[key]; ok {
+			sources[key] = "file"
+		}
+	}
+
+	return sources
+}
+
+// trimConfigEnv strips surrounding whitespace from every configuration key in the
+// environment, so a pasted API key with a trailing newline does not end in a
+// confusing 401, and returns the keys that changed
+func trimConfigEnv() []string {
+	var trimmed []string
+	for _, key := range ConfigKeys() {
+		value, ok := os.LookupEnv(key)
+		if !ok || strings.TrimSpace(value) == value {
+			continue
+		}
+		_ = os.Setenv(key, strings.TrimSpace(value))
+		trimmed = append(trimmed, key)
+	}
+	return trimmed
+}
+
+// Source returns where key was read from: env, file (.env), vault or default
+func (conf Configuration) Source(key string) string {
+	if source, ok := conf.sources[key]; ok {
+		return source
+	}
+	return "default"
+}
+
+// LogConfigKeys logs at debug level which keys were found and where, to spot a
+// misnamed variable. Values are never logged.
+func LogConfigKeys(conf Configuration) {
+	for _, key := range ConfigKeys() {
+		if source := conf.Source(key); source == "default" {
+			Debugf("Config key %s missing, using the default", key)
+		} else {
+			Debugf("Config key %s found (%s)", key, source)
+		}
+	}
+	for _, key := range conf.trimmed {
+		Debugf("Config key %s had surrounding whitespace, trimmed", key)
+	}
+	if conf.smtpPortInferred {
+		Debugf("Config key SmtpPort missing, using %s for SmtpSecurity=%s", conf.SmtpPort, conf.SmtpSecurity)
+	}
+}
+
+// DefaultSmtpPort returns the usual port for an SmtpSecurity mode, or "" when the
+// mode is not known
+func DefaultSmtpPort(security string) string {
+	switch security {
+	case "starttls":
+		return "587"
+	case "tls":
+		return "465"
+	case "none":
+		return "25"
+	}
+	return ""
+}
+
+func DefaultInstanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+// envReader reads typed values from the environment, collecting parse problems
+// instead of exiting so they can all be reported together.
+type envReader struct {
+	problems []error
+}
+
+func (r *envReader) String(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+func (r *envReader) Bool(key string, defaultValue bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Errorf("invalid boolean value for %s: %s", key, value))
+		return defaultValue
+	}
+
+	return parsed
+}
+
+func (r *envReader) Int(key string, defaultValue int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Errorf("invalid integer value for %s: %s", key, value))
+		return defaultValue
+	}
+
+	return parsed
+}
+
+func (r *envReader) Duration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return defaultValue
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Errorf("invalid duration value for %s: %s", key, value))
+		return defaultValue
+	}
+
+	return parsed
+}
+
+func (r *envReader) Regexp(key string, defaultValue string) *regexp.Regexp {
+	value := r.String(key, defaultValue)
+
+	parsed, err := regexp.Compile(value)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Errorf("invalid regular expression for %s: %w", key, err))
+		return regexp.MustCompile(defaultValue)
+	}
+
+	return parsed
+}
+
+// Features parses name and -name toggles, reporting names not in knownFeatures
+func (r *envReader) Features(key string) map[string]bool {
+	features := map[string]bool{}
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		name := strings.TrimPrefix(item, "-")
+		if _, ok := knownFeatures[name]; !ok {
+			r.problems = append(r.problems, fmt.Errorf("unknown feature %q in %s", name, key))
+			continue
+		}
+		features[name] = !strings.HasPrefix(item, "-")
+	}
+
+	return features
+}
+
+func (r *envReader) Statuses(key string) StatusSet {
+	value := os.Getenv(key)
+	if value == "" {
+		return DefaultOkStatuses
+	}
+
+	statuses, err := ParseStatusSet(value)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Errorf("invalid %s: %w", key, err))
+		return DefaultOkStatuses
+	}
+
+	return statuses
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// daemonMode is set by the daemon command, so a failed run is reported and the
+// next one scheduled instead of exiting, see HandleError
+var daemonMode bool
+
+// runAborted carries the error of a run stopped by HandleError in daemon mode
+type runAborted struct {
+	err error
+}
+
+// RunDaemonCommand keeps running syncs until interrupted, every DaemonInterval or
+// aligned to ScheduleAlign, see NextDaemonRun. Runs are unattended so links are
+// updated without prompting. With ConfigSource=vault the configuration is read
+// again before every run. It returns the process exit code.
+func RunDaemonCommand(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.BoolVar(&noCache, "no-cache", false, "read every link fresh, ignoring LinkCacheTtl")
+	_ = flags.Parse(args)
+
+	conf := ReadConfiguration()
+	ConfigureLogging(conf)
+	LogConfigKeys(conf)
+	if problems := conf.Validate(); len(problems) > 0 {
+		fatalf("Invalid configuration:\n%s", FormatProblems(problems))
+	}
+
+	daemonMode = true
+	assumeYes = true
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ConfigureHttpTransport(conf)
+	SetupTracing(conf)
+	defer shutdownTracing()
+
+	daemonStatus.Started = clock.Now()
+	if conf.HealthAddr != "" {
+		StartHealthServer(ctx, conf)
+	}
+
+	// Secrets from Vault are read again before every run so rotations apply, with
+	// the token kept alive in between
+	if conf.ConfigSource == "vault" {
+		go KeepVaultTokenRenewed(ctx)
+	}
+
+	jitter := rand.New(rand.NewSource(clock.Now().UnixNano()))
+	for first := true; ; first = false {
+		if conf.ConfigSource == "vault" && !first {
+			reread := ReadConfiguration()
+			if problems := reread.Validate(); len(problems) > 0 {
+				log.Printf("Configuration re-read from Vault is invalid, keeping the previous one:\n%s", FormatProblems(problems))
+			} else {
+				conf = reread
+			}
+		}
+
+		result, err := RunDaemonIteration(conf)
+		if err != nil {
+			log.Printf("Run failed: %s", err)
+		}
+
+		next := NextDaemonRun(conf, clock.Now(), jitter)
+		daemonStatus.Record(conf, result, err, next)
+		log.SetPrefix("")
+		log.Printf("Next run at %s", next.Format(time.RFC3339))
+
+		select {
+		case <-clock.After(next.Sub(clock.Now())):
+		case <-ctx.Done():
+			log.Print("Stopping daemon")
+			return 0
+		}
+	}
+}
+
+// RunDaemonIteration performs one run, turning a HandleError abort back into an
+// error so the daemon keeps going
+func RunDaemonIteration(conf Configuration) (result *Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			aborted, ok := r.(runAborted)
+			if !ok {
+				panic(r)
+			}
+			err = aborted.err
+			PingMonitor(conf, false)
+		}
+	}()
+
+	return RunOnce(conf)
+}
+
+// NextDaemonRun returns when the daemon should run next after now: DaemonInterval
+// later, or with ScheduleAlign the next wall-clock boundary, plus up to
+// ScheduleJitter of random delay to spread load.
+func NextDaemonRun(conf Configuration, now time.Time, jitter *rand.Rand) time.Time {
+	next := now.Add(conf.DaemonInterval)
+	if conf.ScheduleAlign > 0 {
+		next = NextAlignedTime(now, conf.ScheduleAlign)
+	}
+
+	if conf.ScheduleJitter > 0 {
+		next = next.Add(time.Duration(jitter.Int63n(int64(conf.ScheduleJitter))))
+	}
+
+	return next
+}
+
+// NextAlignedTime returns the first wall-clock time after now that is a multiple
+// of align since local midnight, e.g. the top of the next hour for 1h. Boundaries
+// are computed on the wall clock rather than by adding durations, so they stay on
+// the hour across DST changes; a boundary skipped by a DST change moves forward
+// with the clock. Boundaries restart at every midnight, so an align that does not
+// divide 24h gives a shorter last slot of the day.
+func NextAlignedTime(now time.Time, align time.Duration) time.Time {
+	year, month, day := now.Date()
+	sinceMidnight := time.Duration(now.Hour())*time.Hour +
+		time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second +
+		time.Duration(now.Nanosecond())
+
+	for slot := sinceMidnight/align + 1; ; slot++ {
+		offset := slot * align
+		if offset >= 24*time.Hour {
+			return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+		}
+
+		next := time.Date(year, month, day,
+			int(offset/time.Hour), int(offset%time.Hour/time.Minute), int(offset%time.Minute/time.Second),
+			int(offset%time.Second), now.Location())
+
+		// When the clock falls back the same wall time happens twice, so keep going
+		// until the boundary is actually in the future
+		if next.After(now) {
+			return next
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestNextAlignedTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		align time.Duration
+		want  time.Time
+	}{
+		{"top of the hour", time.Date(2026, 6, 1, 10, 17, 0, 0, newYork), time.Hour, time.Date(2026, 6, 1, 11, 0, 0, 0, newYork)},
+		{"quarter hour", time.Date(2026, 6, 1, 10, 17, 0, 0, newYork), 15 * time.Minute, time.Date(2026, 6, 1, 10, 30, 0, 0, newYork)},
+		{"on a boundary", time.Date(2026, 6, 1, 10, 0, 0, 0, newYork), time.Hour, time.Date(2026, 6, 1, 11, 0, 0, 0, newYork)},
+		{"last slot of the day", time.Date(2026, 6, 1, 23, 30, 0, 0, newYork), time.Hour, time.Date(2026, 6, 2, 0, 0, 0, 0, newYork)},
+		{"clock springs forward", time.Date(2026, 3, 8, 1, 30, 0, 0, newYork), time.Hour, time.Date(2026, 3, 8, 3, 0, 0, 0, newYork)},
+		{"after the spring forward", time.Date(2026, 3, 8, 3, 10, 0, 0, newYork), time.Hour, time.Date(2026, 3, 8, 4, 0, 0, 0, newYork)},
+	}
+
+	for _, test := range tests {
+		if got := NextAlignedTime(test.now, test.align); !got.Equal(test.want) {
+			t.Errorf("%s: NextAlignedTime(%s, %s) = %s, want %s", test.name, test.now, test.align, got, test.want)
+		}
+	}
+}
+
+func TestNextAlignedTimeIsAlwaysInTheFuture(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Across the fall back the same wall times happen twice, and the repeated ones
+	// must not make the schedule stall or run again at once
+	now := time.Date(2026, 11, 1, 0, 0, 0, 0, newYork)
+	for i := 0; i < 8; i++ {
+		next := NextAlignedTime(now, 30*time.Minute)
+		if !next.After(now) || next.Minute()%30 != 0 || next.Second() != 0 {
+			t.Fatalf("NextAlignedTime(%s) = %s", now, next)
+		}
+		now = next
+	}
+}
+
+func TestNextDaemonRun(t *testing.T) {
+	fake := NewFakeClock(time.Date(2026, 6, 1, 10, 17, 0, 0, time.UTC))
+	defer func(previous Clock) { clock = previous }(clock)
+	clock = fake
+
+	conf := Configuration{DaemonInterval: time.Hour}
+	if got, want := NextDaemonRun(conf, clock.Now(), nil), fake.Now().Add(time.Hour); !got.Equal(want) {
+		t.Errorf("NextDaemonRun = %s, want %s", got, want)
+	}
+
+	conf.ScheduleAlign = time.Hour
+	conf.ScheduleJitter = 5 * time.Minute
+	jitter := rand.New(rand.NewSource(1))
+	aligned := time.Date(2026, 6, 1, 11, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		got := NextDaemonRun(conf, clock.Now(), jitter)
+		if got.Before(aligned) || !got.Before(aligned.Add(conf.ScheduleJitter)) {
+			t.Fatalf("NextDaemonRun = %s, want within ScheduleJitter after %s", got, aligned)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DeadLetter is a notification a notifier failed to deliver after MaxNotifyRetries,
+// kept in DeadLetterDir until notify-replay delivers it
+type DeadLetter struct {
+	Notifier     string       `json:"notifier"`
+	Error        string       `json:"error"`
+	Notification Notification `json:"notification"`
+}
+
+// WriteDeadLetter saves an undelivered notification, if DeadLetterDir is set
+func WriteDeadLetter(conf Configuration, notifier string, notification Notification, cause error) {
+	if conf.DeadLetterDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(DeadLetter{Notifier: notifier, Error: cause.Error(), Notification: notification}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(conf.DeadLetterDir, 0o700)
+	}
+	if err != nil {
+		log.Printf("Could not dead-letter %s notification: %s", notifier, err)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", notification.Time.UTC().Format("20060102T150405Z"), notifier, NewUuid())
+	path := filepath.Join(conf.DeadLetterDir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		log.Printf("Could not dead-letter %s notification: %s", notifier, err)
+		return
+	}
+
+	log.Printf("Undelivered %s notification written to %s, resend it with notify-replay", notifier, path)
+}
+
+// RunNotifyReplayCommand resends every dead-lettered notification through the
+// notifier it failed on, oldest first, removing each one that is delivered. It
+// returns the process exit code, 1 if any remain.
+func RunNotifyReplayCommand() int {
+	conf := ReadConfiguration()
+	if conf.DeadLetterDir == "" {
+		fmt.Println("DeadLetterDir must be set to replay notifications")
+		return 1
+	}
+	ConfigureHttpTransport(conf)
+
+	paths, err := filepath.Glob(filepath.Join(conf.DeadLetterDir, "*.json"))
+	if err != nil {
+		fmt.Printf("Listing %s failed: %s\n", conf.DeadLetterDir, err)
+		return 1
+	}
+	sort.Strings(paths)
+
+	remaining := 0
+	for _, path := range paths {
+		if err := replayDeadLetter(conf, path); err != nil {
+			fmt.Printf("%s: %s\n", filepath.Base(path), err)
+			remaining++
+			continue
+		}
+		fmt.Printf("%s: delivered\n", filepath.Base(path))
+	}
+
+	fmt.Printf("%d delivered, %d remaining\n", len(paths)-remaining, remaining)
+	if remaining > 0 {
+		return 1
+	}
+	return 0
+}
+
+func replayDeadLetter(conf Configuration, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	letter := DeadLetter{}
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return err
+	}
+
+	var notifier Notifier
+	for _, provider := range notifierProviders {
+		if strings.EqualFold(provider.Name, letter.Notifier) {
+			notifier = provider.New(conf)
+		}
+	}
+	if notifier == nil {
+		return fmt.Errorf("unknown notifier %q", letter.Notifier)
+	}
+
+	if err := notifier.Notify(context.Background(), conf, letter.Notification); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// EmailNotifier sends notifications over SMTP to the EmailEnvelopeFor their level
+type EmailNotifier struct{}
+
+func (EmailNotifier) Name() string {
+	return "email"
+}
+
+func (EmailNotifier) Notify(ctx context.Context, conf Configuration, notification Notification) error {
+	envelope := EmailEnvelopeFor(conf, notification.Level)
+	if len(envelope.All()) == 0 {
+		return nil
+	}
+	return SendGmailEmail(conf, envelope, notification.Subject, notification.Body, notification.Attachments...)
+}
+
+func SendGmailEmail(conf Configuration, envelope EmailEnvelope, emailSubject string, emailBody string, attachments ...EmailAttachment) error {
+
+	message, err := buildMessage(conf.EmailCharset, envelope, emailSubject, emailBody, attachments)
+	if err != nil {
+		return err
+	}
+
+	// Send actual message
+	return SendSmtp(conf, conf.SmtpFromEmail, envelope.All(), message)
+}
+
+// buildMessage renders the raw email. Without attachments this is just a subject and
+// a plain body, otherwise a multipart/mixed MIME message with base64 encoded parts.
+// The subject and body are encoded in charset, which is declared on the text part.
+// BCC recipients are left out of the headers.
+func buildMessage(charset string, envelope EmailEnvelope, emailSubject string, emailBody string, attachments []EmailAttachment) ([]byte, error) {
+	subject, body, err := encodeText(charset, emailSubject, emailBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	if len(envelope.To) > 0 {
+		message.WriteString("To: " + strings.Join(envelope.To, ", ") + "\r\n")
+	}
+	if len(envelope.Cc) > 0 {
+		message.WriteString("Cc: " + strings.Join(envelope.Cc, ", ") + "\r\n")
+	}
+	message.WriteString("Subject: " + subject + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		writeTextPart(&message, charset, body)
+		return message.Bytes(), nil
+	}
+
+	boundary := newMimeBoundary()
+
+	message.WriteString("Content-Type: multipart/mixed; boundary=\"" + boundary + "\"\r\n\r\n")
+
+	message.WriteString("--" + boundary + "\r\n")
+	writeTextPart(&message, charset, body)
+	message.WriteString("\r\n")
+
+	for _, attachment := range attachments {
+		message.WriteString("--" + boundary + "\r\n")
+		message.WriteString("Content-Type: " + attachment.ContentType + "\r\n")
+		message.WriteString("Content-Transfer-Encoding: base64\r\n")
+		message.WriteString("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}) + "\r\n\r\n")
+		writeBase64Lines(&message, attachment.Data)
+	}
+
+	message.WriteString("--" + boundary + "--\r\n")
+
+	return message.Bytes(), nil
+}
+
+// encodeText converts the subject and body from UTF-8 into charset. The subject is
+// returned as an RFC 2047 encoded word whenever it is not plain ASCII. Characters
+// charset cannot represent, such as emoji in a campaign title, are replaced rather
+// than failing the notification.
+func encodeText(charset string, emailSubject string, emailBody string) (string, []byte, error) {
+	charsetEncoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", nil, fmt.Errorf("unsupported EmailCharset %q", charset)
+	}
+
+	subject, err := encoding.ReplaceUnsupported(charsetEncoding.NewEncoder()).String(emailSubject)
+	if err != nil {
+		return "", nil, fmt.Errorf("encoding subject as %s: %w", charset, err)
+	}
+	body, err := encoding.ReplaceUnsupported(charsetEncoding.NewEncoder()).String(emailBody)
+	if err != nil {
+		return "", nil, fmt.Errorf("encoding body as %s: %w", charset, err)
+	}
+
+	return mime.QEncoding.Encode(charset, subject), []byte(body), nil
+}
+
+// writeTextPart writes the headers and quoted-printable body of the text part
+func writeTextPart(message *bytes.Buffer, charset string, body []byte) {
+	message.WriteString("Content-Type: " + mime.FormatMediaType("text/plain", map[string]string{"charset": charset}) + "\r\n")
+	message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(message)
+	_, _ = writer.Write(body)
+	_ = writer.Close()
+}
+
+// writeBase64Lines wraps the encoded data at 76 characters as required by RFC 2045
+func writeBase64Lines(message *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		message.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	message.WriteString(encoded + "\r\n")
+}
+
+func newMimeBoundary() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "boundary-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DaemonStatus is what the daemon exposes on HealthAddr. It is only written between
+// runs by the daemon loop, which also snapshots the caches, so handlers never read
+// state a run is changing. There is no circuit breaker to report: every run calls
+// each service again, with only the per-request retries of SendRequest.
+type DaemonStatus struct {
+	mu sync.Mutex
+
+	Started    time.Time              `json:"started"`
+	Runs       int                    `json:"runs"`
+	Failures   int                    `json:"failures"`
+	Metrics    DaemonMetrics          `json:"metrics"`
+	LastRunAt  time.Time              `json:"last_run_at,omitempty"`
+	NextRunAt  time.Time              `json:"next_run_at,omitempty"`
+	LastError  string                 `json:"last_error,omitempty"`
+	LastResult *Result                `json:"last_result,omitempty"`
+	Config     map[string]string      `json:"config"`
+	Caches     map[string]interface{} `json:"caches"`
+}
+
+// DaemonMetrics are the counters added up over every run since the daemon started
+type DaemonMetrics struct {
+	// Retries counts the request retries per stage, such as mailchimp or urlday-put
+	Retries map[string]int `json:"retries"`
+	// Failures counts the failed runs per stage of the error, "unknown" without one
+	Failures map[string]int `json:"failures"`
+}
+
+var daemonStatus = &DaemonStatus{Metrics: DaemonMetrics{Retries: map[string]int{}, Failures: map[string]int{}}}
+
+// Record stores the outcome of a run, adds its retries and any failure to the
+// metrics and snapshots the configuration, with secrets redacted, the rate limiter
+// and the caches
+func (s *DaemonStatus) Record(conf Configuration, result *Result, err error, next time.Time) {
+	caches := map[string]interface{}{
+		"urlday_link_ids":    copyStringMap(urlDayLinkIds),
+		"mailchimp_list_ids": copyStringMap(mailChimpListIds),
+		"urlday_rate_limit":  urlDayLimiter.Snapshot(),
+	}
+	if state, loadErr := NewStateStore(conf).Load(); loadErr == nil {
+		caches["link_cache"] = state.LinkCache
+		caches["recreated_link_ids"] = state.RecreatedLinkIds
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Runs++
+	s.LastRunAt = clock.Now()
+	s.NextRunAt = next
+	s.LastResult = result
+	s.LastError = ""
+	if result != nil {
+		for stage, retries := range result.Retries {
+			s.Metrics.Retries[stage] += retries
+		}
+	}
+	if err != nil {
+		s.Failures++
+		s.LastError = err.Error()
+
+		stage := ErrorStage(err)
+		if stage == "" {
+			stage = "unknown"
+		}
+		s.Metrics.Failures[stage]++
+	}
+	s.Config = RedactedConfig(conf)
+	s.Caches = caches
+}
+
+func copyStringMap(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
+
+// RedactedConfig returns every key with its display value as shown by config show
+func RedactedConfig(conf Configuration) map[string]string {
+	config := map[string]string{}
+	value := reflect.ValueOf(conf)
+	for i := 0; i < value.NumField(); i++ {
+		if field := value.Type().Field(i); field.IsExported() {
+			config[field.Name] = configDisplayValue(field.Name, value.Field(i))
+		}
+	}
+	return config
+}
+
+// StartHealthServer serves /healthz and /debug on HealthAddr until ctx is done.
+// /healthz answers 503 while the last run failed. /debug returns the DaemonStatus
+// as JSON and, as it exposes internal state, requires DebugToken as a bearer token
+// when one is set.
+func StartHealthServer(ctx context.Context, conf Configuration) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		daemonStatus.mu.Lock()
+		failing := daemonStatus.LastError != ""
+		daemonStatus.mu.Unlock()
+
+		if failing {
+			http.Error(w, "last run failed", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {
+		if conf.DebugToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+conf.DebugToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		daemonStatus.mu.Lock()
+		defer daemonStatus.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(daemonStatus)
+	})
+
+	server := &http.Server{Addr: conf.HealthAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		log.Printf("Health server listening on %s", conf.HealthAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Health server stopped: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDaemonStatusRecordAddsUpMetrics(t *testing.T) {
+	status := &DaemonStatus{Metrics: DaemonMetrics{Retries: map[string]int{}, Failures: map[string]int{}}}
+	conf := Configuration{StateFile: filepath.Join(t.TempDir(), "state.json")}
+
+	first := NewResult()
+	first.CountRetries("mailchimp", 2)
+	first.CountRetries("urlday-put", 1)
+	status.Record(conf, first, nil, time.Time{})
+
+	second := NewResult()
+	second.CountRetries("mailchimp", 1)
+	status.Record(conf, second, StageError("urlday-put", errors.New("502")), time.Time{})
+	status.Record(conf, nil, errors.New("no stage"), time.Time{})
+
+	if status.Runs != 3 || status.Failures != 2 {
+		t.Errorf("got %d runs and %d failures, want 3 and 2", status.Runs, status.Failures)
+	}
+	if status.Metrics.Retries["mailchimp"] != 3 || status.Metrics.Retries["urlday-put"] != 1 {
+		t.Errorf("retries = %v, want mailchimp 3 and urlday-put 1", status.Metrics.Retries)
+	}
+	if status.Metrics.Failures["urlday-put"] != 1 || status.Metrics.Failures["unknown"] != 1 {
+		t.Errorf("failures = %v, want urlday-put 1 and unknown 1", status.Metrics.Failures)
+	}
+}
+
+func TestErrorStage(t *testing.T) {
+	err := StageError("urlday-get", &UnexpectedStatusError{Stage: "urlday-get", StatusCode: 500})
+	if stage := ErrorStage(err); stage != "urlday-get" {
+		t.Errorf("ErrorStage = %q, want urlday-get", stage)
+	}
+	var statusErr *UnexpectedStatusError
+	if !errors.As(err, &statusErr) {
+		t.Error("the staged error no longer unwraps")
+	}
+	if stage := ErrorStage(errors.New("plain")); stage != "" {
+		t.Errorf("ErrorStage of an unstaged error = %q", stage)
+	}
+}
+
+func TestRedactedConfigHidesVaultCredentials(t *testing.T) {
+	config := RedactedConfig(Configuration{VaultRoleId: "role", VaultSecretId: "secret", VaultPath: "secret/data/app"})
+
+	for _, key := range []string{"VaultRoleId", "VaultSecretId"} {
+		if config[key] != "[REDACTED]" {
+			t.Errorf("%s = %s, want it redacted", key, config[key])
+		}
+	}
+	if config["VaultPath"] == "[REDACTED]" {
+		t.Error("VaultPath is not a credential but was redacted")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+)
+
+// HistoryEntry is one mirrored campaign in the State history
+type HistoryEntry struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	SendTime string `json:"send_time"`
+	Url      string `json:"url"`
+}
+
+// historyPageSize is how many campaigns each history request fetches
+const historyPageSize = 100
+
+// FetchHistory returns the campaigns sent after cursor, a send_time, oldest first,
+// following the pages until all have been read. Without a cursor it starts from
+// MailChimpSinceSendTime, or the very first campaign.
+func FetchHistory(ctx context.Context, conf Configuration, result *Result, cursor string) ([]MailChimpCampaign, error) {
+	if cursor == "" {
+		cursor = conf.MailChimpSinceSendTime
+	}
+
+	var campaigns []MailChimpCampaign
+	for offset := 0; ; offset += historyPageSize {
+		url := MailChimpApiUrl(conf, fmt.Sprintf("/campaigns?status=sent&sort_field=send_time&sort_dir=ASC&count=%d&offset=%d", historyPageSize, offset))
+		if cursor != "" {
+			url = url + "&since_send_time=" + neturl.QueryEscape(cursor)
+		}
+		if conf.MailChimpListId != "" {
+			url = url + "&list_id=" + neturl.QueryEscape(conf.MailChimpListId)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Accept", "application/json")
+		req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+		resp, err := SendRequest(conf, result, "mailchimp", conf.MailChimpOkStatuses, req)
+		if err != nil {
+			return nil, err
+		}
+
+		page := MailChimpSent{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		campaigns = append(campaigns, page.Campaigns...)
+		if len(page.Campaigns) < historyPageSize {
+			return campaigns, nil
+		}
+	}
+}
+
+// AddHistory appends the campaigns not yet in the history, advances the cursor to
+// the newest send_time and keeps at most HistoryMaxEntries entries
+func (s *State) AddHistory(conf Configuration, campaigns []MailChimpCampaign) {
+	known := map[string]bool{}
+	for _, entry := range s.History {
+		known[entry.Id] = true
+	}
+
+	for _, campaign := range campaigns {
+		if !known[campaign.Id] {
+			known[campaign.Id] = true
+			s.History = append(s.History, HistoryEntry{
+				Id:       campaign.Id,
+				Title:    campaign.Settings.Title,
+				SendTime: campaign.SendTime,
+				Url:      campaign.UrlField(conf.MailChimpUrlField),
+			})
+		}
+		if campaign.SendTime > s.HistoryCursor {
+			s.HistoryCursor = campaign.SendTime
+		}
+	}
+
+	if conf.HistoryMaxEntries > 0 && len(s.History) > conf.HistoryMaxEntries {
+		s.History = s.History[len(s.History)-conf.HistoryMaxEntries:]
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// messageFiles holds the notification texts, one messages/<language>.json per
+// NotifyLanguage. To add a language, copy messages/en.json and translate the
+// values; keys missing from a translation fall back to English.
+//
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+// Messages are the notification texts for one language, keyed as in messages/en.json
+type Messages map[string]string
+
+// LoadMessages returns the texts for language with any missing keys taken from English
+func LoadMessages(language string) Messages {
+	messages, err := readMessages("en")
+	if err != nil {
+		fatalf("Embedded English messages are invalid: %s", err)
+	}
+
+	if language != "en" {
+		translated, err := readMessages(language)
+		if err != nil {
+			log.Printf("No usable messages for NotifyLanguage %q, using English: %s", language, err)
+		}
+		for key, text := range translated {
+			messages[key] = text
+		}
+	}
+
+	return messages
+}
+
+// HasMessages reports whether an embedded translation exists for language
+func HasMessages(language string) bool {
+	_, err := messageFiles.Open("messages/" + language + ".json")
+	return err == nil
+}
+
+func readMessages(language string) (Messages, error) {
+	data, err := messageFiles.ReadFile("messages/" + language + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	messages := Messages{}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Format renders the text for key with fmt style arguments
+func (m Messages) Format(key string, args ...interface{}) string {
+	text, ok := m[key]
+	if !ok {
+		text = key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where systemd-journald accepts native protocol datagrams
+const journalSocket = "/run/systemd/journal/socket"
+
+// journal is set with LogOutput=journald, see ConfigureLogging
+var journal *JournalWriter
+
+// JournalWriter sends log records to systemd-journald using its native protocol,
+// so journalctl can filter on priority, SYSLOG_IDENTIFIER and RUN_ID. It is also
+// the log output, where every line is info priority unless it starts with Warning.
+type JournalWriter struct {
+	conn net.Conn
+}
+
+func NewJournalWriter() (*JournalWriter, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{conn: conn}, nil
+}
+
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+
+	priority := priorityInfo
+	if strings.HasPrefix(strings.TrimPrefix(message, "["+runId+"] "), "Warning") {
+		priority = priorityWarning
+	}
+
+	w.Send(priority, message, nil)
+	return len(p), nil
+}
+
+// Send writes one record with extra fields, whose names must be upper case. A
+// record the journal does not take is written to stderr instead, so it is not lost.
+func (w *JournalWriter) Send(priority int, message string, fields map[string]string) {
+	record := &bytes.Buffer{}
+	writeJournalField(record, "PRIORITY", strconv.Itoa(priority))
+	writeJournalField(record, "SYSLOG_IDENTIFIER", "mailchimptowebsite")
+	writeJournalField(record, "MESSAGE", message)
+	if runId != "" {
+		writeJournalField(record, "RUN_ID", runId)
+	}
+	for name, value := range fields {
+		writeJournalField(record, name, value)
+	}
+
+	if _, err := w.conn.Write(record.Bytes()); err != nil {
+		_, _ = os.Stderr.WriteString(message + "\n")
+	}
+}
+
+// writeJournalField encodes a field, using the length prefixed form for values
+// containing newlines as the protocol requires
+func writeJournalField(record *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		record.WriteString(name + "=" + value + "\n")
+		return
+	}
+
+	record.WriteString(name + "\n")
+	_ = binary.Write(record, binary.LittleEndian, uint64(len(value)))
+	record.WriteString(value + "\n")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// LinkService is where the latest campaign URL is mirrored to, selected with
+// LinkProvider. Link ids are whatever identifies a single link for the provider.
+type LinkService interface {
+	Name() string
+	GetURL(ctx context.Context, conf Configuration, result *Result, linkId string) (string, error)
+	UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error
+}
+
+// LinkUpdate is one link to point at Url
+type LinkUpdate struct {
+	LinkId string
+	Url    string
+}
+
+// BatchLinkService is implemented by providers that can update several links in
+// one request, see UpdateLinks
+type BatchLinkService interface {
+	LinkService
+	UpdateURLs(ctx context.Context, conf Configuration, result *Result, updates []LinkUpdate) error
+}
+
+// UpdateLinks applies updates with a single batch request when there are several
+// and the service supports it, and with one UpdateURL per link otherwise. UrlDay has
+// no batch endpoint, so its links are always updated one request at a time.
+func UpdateLinks(ctx context.Context, conf Configuration, result *Result, service LinkService, updates []LinkUpdate) error {
+	if batch, ok := service.(BatchLinkService); ok && len(updates) > 1 {
+		return batch.UpdateURLs(ctx, conf, result, updates)
+	}
+
+	for _, update := range updates {
+		if err := service.UpdateURL(ctx, conf, result, update.LinkId, update.Url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewLinkService returns the service selected by LinkProvider, defaulting to UrlDay
+func NewLinkService(conf Configuration) LinkService {
+	var service LinkService = UrlDayService{}
+	if provider := FindLinkProvider(conf.LinkProvider); provider != nil {
+		service = provider.New(conf)
+	}
+
+	if conf.ReadOnly {
+		return ReadOnlyService{LinkService: service}
+	}
+	return service
+}
+
+// errReadOnly is returned for any update attempted with ReadOnly=true
+var errReadOnly = errors.New("not updating, ReadOnly is set")
+
+// ReadOnlyService wraps a LinkService for ReadOnly=true, so no code path can
+// change a link no matter what calls it
+type ReadOnlyService struct {
+	LinkService
+}
+
+func (s ReadOnlyService) UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error {
+	log.Printf("ReadOnly, would have updated %s link %s to %s", s.Name(), linkId, url)
+	return errReadOnly
+}
+
+// UrlDayService mirrors to UrlDay short links
+type UrlDayService struct{}
+
+func (UrlDayService) Name() string {
+	return "urlday"
+}
+
+func (UrlDayService) GetURL(ctx context.Context, conf Configuration, result *Result, linkId string) (string, error) {
+	return GetCurrentUrlDay(ctx, conf, result, linkId)
+}
+
+func (UrlDayService) UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error {
+	return UpdateUrlDay(ctx, conf, result, linkId, url)
+}
+
+// VerifyLinkUpdate reads the link back to confirm the update stuck. This costs an
+// extra API call, which can be skipped with VerifyUpdate=false. Providers may
+// briefly keep serving the old value, so a mismatch is re-read up to
+// VerifyReadRetries times, VerifyReadDelay apart, before it counts as a failure.
+func VerifyLinkUpdate(ctx context.Context, conf Configuration, result *Result, service LinkService, linkId string, expectedUrl string) error {
+	for attempt := 0; ; attempt++ {
+		currentUrl, err := service.GetURL(ctx, conf, result, linkId)
+		if err != nil {
+			return err
+		}
+		if urlsEqual(conf, currentUrl, expectedUrl) {
+			return nil
+		}
+
+		if attempt >= conf.VerifyReadRetries {
+			return fmt.Errorf("issue with %s update, read back %q but expected %q after %d reads", service.Name(), currentUrl, expectedUrl, attempt+1)
+		}
+
+		log.Printf("%s link %s still returns %q, re-reading in %s", service.Name(), linkId, currentUrl, conf.VerifyReadDelay)
+		clock.Sleep(conf.VerifyReadDelay)
+	}
+}
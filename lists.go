@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mailChimpListIds caches MailChimpListName lookups, so a daemon resolves the name once
+var mailChimpListIds = map[string]string{}
+
+// ResolveMailChimpListId returns MailChimpListId, or the id of the audience named
+// MailChimpListName. Names are compared case insensitively and must match exactly
+// one audience.
+func ResolveMailChimpListId(ctx context.Context, conf Configuration, result *Result) (string, error) {
+	if conf.MailChimpListName == "" {
+		return conf.MailChimpListId, nil
+	}
+	if id, ok := mailChimpListIds[conf.MailChimpListName]; ok {
+		return id, nil
+	}
+
+	url := MailChimpApiUrl(conf, "/lists?fields=lists.id,lists.name,total_items&count=1000")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+	resp, err := SendRequest(conf, result, "mailchimp", conf.MailChimpOkStatuses, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var lists struct {
+		Lists []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"lists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&lists); err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, list := range lists.Lists {
+		if strings.EqualFold(strings.TrimSpace(list.Name), strings.TrimSpace(conf.MailChimpListName)) {
+			matches = append(matches, list.Id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no MailChimp audience named %q, check MailChimpListName", conf.MailChimpListName)
+	case 1:
+		mailChimpListIds[conf.MailChimpListName] = matches[0]
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("MailChimpListName %q matches %d audiences (%s), set MailChimpListId instead",
+			conf.MailChimpListName, len(matches), strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// debugLogging enables Debugf output, set from LogLevel=debug
+var debugLogging bool
+
+// Syslog priorities, as used by the journal
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityNotice  = 5
+	priorityInfo    = 6
+	priorityDebug   = 7
+)
+
+// ConfigureLogging applies LogLevel and LogOutput. If the journal cannot be reached
+// logging stays on stderr.
+func ConfigureLogging(conf Configuration) {
+	debugLogging = conf.LogLevel == "debug"
+
+	if conf.LogOutput == "journald" {
+		writer, err := NewJournalWriter()
+		if err != nil {
+			log.Printf("Could not connect to the journal, logging to stderr: %s", err)
+			return
+		}
+		journal = writer
+		log.SetOutput(writer)
+		log.SetFlags(0)
+	}
+}
+
+// Debugf logs like log.Printf, but only at LogLevel=debug
+func Debugf(format string, args ...interface{}) {
+	if debugLogging {
+		logAt(priorityDebug, format, args...)
+	}
+}
+
+// logAt logs with a journal priority, which plain stderr logging has no use for
+func logAt(priority int, format string, args ...interface{}) {
+	if journal == nil {
+		log.Printf(format, args...)
+		return
+	}
+	journal.Send(priority, log.Prefix()+fmt.Sprintf(format, args...), nil)
+}
+
+// fatal logs err at error priority and exits, like log.Fatal
+func fatal(err error) {
+	logAt(priorityErr, "%s", err)
+	os.Exit(1)
+}
+
+// fatalf logs at error priority and exits, like log.Fatalf
+func fatalf(format string, args ...interface{}) {
+	logAt(priorityErr, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MailChimpCampaign struct {
+	Id             string `json:"id"`
+	WebId          int    `json:"web_id"`
+	ArchiveUrl     string `json:"archive_url"`
+	LongArchiveUrl string `json:"long_archive_url"`
+	Status         string `json:"status"`
+	SendTime       string `json:"send_time"`
+	EmailsSent     int    `json:"emails_sent"`
+	Settings       struct {
+		SubjectLine string `json:"subject_line"`
+		Title       string `json:"title"`
+	} `json:"settings"`
+}
+
+type MailChimpSent struct {
+	TotalItems int                 `json:"total_items"`
+	Campaigns  []MailChimpCampaign `json:"campaigns"`
+}
+
+// IsMailChimpUrlField reports whether field names a campaign URL that can be mirrored
+func IsMailChimpUrlField(field string) bool {
+	return field == "archive_url" || field == "long_archive_url"
+}
+
+// UrlField returns the campaign URL for a MailChimpUrlField style field name
+func (c *MailChimpCampaign) UrlField(field string) string {
+	if field == "archive_url" {
+		return c.ArchiveUrl
+	}
+	return c.LongArchiveUrl
+}
+
+// GetLatestMailChimpCampaign returns the most recently sent campaign, or nil if
+// nothing has been sent yet.
+func GetLatestMailChimpCampaign(ctx context.Context, conf Configuration, result *Result) *MailChimpCampaign {
+	ctx, span := tracer.Start(ctx, "mailchimp")
+	defer span.End()
+
+	url := MailChimpCampaignsUrl(conf, conf.MailChimpFetchCount)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		HandleError(conf, StageError("mailchimp", err))
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+	resp, err := SendRequest(conf, result, "mailchimp", conf.MailChimpOkStatuses, req)
+	if err != nil {
+		HandleError(conf, StageError("mailchimp", err))
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			HandleError(conf, StageError("mailchimp", err))
+		}
+	}(resp.Body)
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	// Convert response body to MailChimpSent struct
+	mailchimpSent := MailChimpSent{}
+	err = json.Unmarshal(bodyBytes, &mailchimpSent)
+	if err != nil {
+		HandleError(conf, StageError("mailchimp", err))
+	}
+
+	candidates := CandidateCampaigns(conf, mailchimpSent.Campaigns)
+
+	// Small sends newer than what gets mirrored are reported, as they would
+	// otherwise have been the latest newsletter
+	for i, campaign := range mailchimpSent.Campaigns {
+		if len(candidates) > 0 && i >= candidates[0] {
+			break
+		}
+		if campaign.EmailsSent < conf.MinEmailsSent {
+			if result.SmallCampaignId == "" {
+				result.SmallCampaignId = campaign.Id
+			}
+			result.SmallCampaigns = append(result.SmallCampaigns, fmt.Sprintf("%s (%d emails)", campaign.Id, campaign.EmailsSent))
+		}
+	}
+
+	// Without a campaign to mirror the links are left alone, see SyncLink
+	if len(candidates) == 0 {
+		if len(mailchimpSent.Campaigns) == 0 && conf.MailChimpListId != "" {
+			result.NoCampaignReason = "no sent campaigns found in list " + conf.MailChimpListId
+		} else if len(mailchimpSent.Campaigns) == 0 {
+			result.NoCampaignReason = "no sent campaigns found"
+		} else {
+			result.NoCampaignReason = fmt.Sprintf("all %d fetched campaigns were excluded by MinEmailsSent, ExcludeTestCampaigns or ClockSkewToleranceSeconds", len(mailchimpSent.Campaigns))
+		}
+		log.Printf("No campaign to mirror, %s", result.NoCampaignReason)
+		return nil
+	}
+
+	// Rather than tie-breaking, a human decides which of several campaigns sent at
+	// the same moment should be mirrored
+	if conf.FailOnAmbiguousLatest {
+		if tied, sendTime := TiedNewestCampaigns(mailchimpSent.Campaigns, candidates); len(tied) > 1 {
+			HandleError(conf, StageError("mailchimp", fmt.Errorf("campaigns %s share the newest send_time %s, not choosing which to mirror with FailOnAmbiguousLatest set",
+				strings.Join(tied, ", "), sendTime)))
+		}
+	}
+
+	index := candidates[0]
+	if conf.VerifyLatestSendTime {
+		index = NewestCampaignIndex(mailchimpSent.Campaigns, candidates)
+		if index != candidates[0] {
+			log.Printf("Warning: campaign %s is not the newest by send_time, using %s instead; check the campaigns sort order",
+				mailchimpSent.Campaigns[candidates[0]].Id, mailchimpSent.Campaigns[index].Id)
+		}
+	}
+
+	// MailChimpOffset deliberately mirrors an earlier campaign, counted from the newest
+	if conf.MailChimpOffset > 0 {
+		ordered := candidates
+		if conf.VerifyLatestSendTime {
+			ordered = CandidatesBySendTime(mailchimpSent.Campaigns, candidates)
+		}
+		if conf.MailChimpOffset >= len(ordered) {
+			log.Printf("Warning: MailChimpOffset %d is out of range, only %d candidate campaigns were fetched", conf.MailChimpOffset, len(ordered))
+			result.NoCampaignReason = fmt.Sprintf("MailChimpOffset %d out of range, %d candidates", conf.MailChimpOffset, len(ordered))
+			return nil
+		}
+		index = ordered[conf.MailChimpOffset]
+		log.Printf("Mirroring campaign %s at MailChimpOffset %d instead of the newest, %s",
+			mailchimpSent.Campaigns[index].Id, conf.MailChimpOffset, mailchimpSent.Campaigns[ordered[0]].Id)
+	}
+
+	campaign := mailchimpSent.Campaigns[index]
+	result.LatestCampaignId = campaign.Id
+	result.LatestWebId = campaign.WebId
+	if conf.NotifyReportLink && campaign.WebId != 0 {
+		result.ReportUrl = MailChimpReportUrl(conf, campaign.WebId)
+	}
+
+	// Some accounts get list entries without archive URLs, while the full campaign
+	// record has them. Only worth the extra call when something is missing.
+	if conf.ArchiveUrlFallback && (campaign.ArchiveUrl == "" || campaign.LongArchiveUrl == "") {
+		full, err := GetMailChimpCampaign(ctx, conf, result, campaign.Id)
+		if err != nil {
+			HandleError(conf, StageError("mailchimp", err))
+		}
+		if campaign.ArchiveUrl == "" {
+			campaign.ArchiveUrl = full.ArchiveUrl
+		}
+		if campaign.LongArchiveUrl == "" {
+			campaign.LongArchiveUrl = full.LongArchiveUrl
+		}
+		log.Printf("Campaign %s was missing an archive URL, filled in from /campaigns/%s", campaign.Id, campaign.Id)
+	}
+
+	if len(conf.ArchiveDomainRewrite) > 0 {
+		for _, archiveUrl := range []*string{&campaign.ArchiveUrl, &campaign.LongArchiveUrl} {
+			rewritten, err := RewriteArchiveDomain(conf, *archiveUrl)
+			if err != nil {
+				HandleError(conf, StageError("mailchimp", err))
+			}
+			*archiveUrl = rewritten
+		}
+	}
+
+	if conf.StrictResponseValidation {
+		if err := ValidateCampaign(conf, &campaign); err != nil {
+			HandleError(conf, StageError("mailchimp", err))
+		}
+	}
+
+	if conf.AttachCampaignJson {
+		result.LatestCampaignJson = RedactedCampaignJson(bodyBytes, index)
+	}
+
+	return &campaign
+}
+
+// MailChimpApiUrl builds an API URL from MailChimpBaseUrl, which defaults to the
+// datacenter of MailChimpServerPrefix, and MailChimpApiVersion, which defaults to 3.0
+func MailChimpApiUrl(conf Configuration, path string) string {
+	base := conf.MailChimpBaseUrl
+	if base == "" {
+		base = "https://" + conf.MailChimpServerPrefix + ".api.mailchimp.com"
+	}
+	version := conf.MailChimpApiVersion
+	if version == "" {
+		version = "3.0"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + version + path
+}
+
+// MailChimpReportUrl fills MailChimpReportUrlTemplate for a campaign. The admin
+// pages identify campaigns by web_id rather than the API id, and their URL is not
+// part of the API, hence the template.
+func MailChimpReportUrl(conf Configuration, webId int) string {
+	return strings.NewReplacer(
+		"{{server_prefix}}", conf.MailChimpServerPrefix,
+		"{{web_id}}", strconv.Itoa(webId),
+	).Replace(conf.MailChimpReportUrlTemplate)
+}
+
+// MailChimpCampaignsUrl is the /campaigns query for up to count sent campaigns,
+// newest first, limited to MailChimpSinceSendTime and MailChimpListId when set
+func MailChimpCampaignsUrl(conf Configuration, count int) string {
+	url := MailChimpApiUrl(conf, fmt.Sprintf("/campaigns?status=sent&sort_field=send_time&sort_dir=DESC&count=%d", count))
+	if conf.MailChimpSinceSendTime != "" {
+		url = url + "&since_send_time=" + neturl.QueryEscape(conf.MailChimpSinceSendTime)
+	}
+	if conf.MailChimpListId != "" {
+		url = url + "&list_id=" + neturl.QueryEscape(conf.MailChimpListId)
+	}
+	return url
+}
+
+// RewriteArchiveDomain replaces the host of an archive URL according to
+// ArchiveDomainRewrite, keeping the scheme, path and query
+func RewriteArchiveDomain(conf Configuration, archiveUrl string) (string, error) {
+	if archiveUrl == "" {
+		return "", nil
+	}
+
+	parsed, err := neturl.Parse(archiveUrl)
+	if err != nil {
+		return "", fmt.Errorf("cannot rewrite archive URL %q: %w", archiveUrl, err)
+	}
+
+	host, ok := conf.ArchiveDomainRewrite[strings.ToLower(parsed.Host)]
+	if !ok {
+		return archiveUrl, nil
+	}
+	parsed.Host = host
+
+	rewritten := parsed.String()
+	if check, err := neturl.Parse(rewritten); err != nil || check.Host != host {
+		return "", fmt.Errorf("archive URL %q rewritten to an invalid URL %q", archiveUrl, rewritten)
+	}
+
+	return rewritten, nil
+}
+
+// ValidateCampaign checks the fields the sync depends on are present, so a change in
+// the MailChimp API fails loudly instead of mirroring empty values
+func ValidateCampaign(conf Configuration, campaign *MailChimpCampaign) error {
+	var missing []string
+	if campaign.Id == "" {
+		missing = append(missing, "id")
+	}
+	reported := map[string]bool{}
+	for _, target := range LinkTargets(conf) {
+		if campaign.UrlField(target.UrlField) == "" && !reported[target.UrlField] {
+			reported[target.UrlField] = true
+			missing = append(missing, target.UrlField)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("MailChimp campaign %q is missing %s, the API response may have changed", campaign.Id, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// CandidateCampaigns returns the indexes of the fetched campaigns that may be
+// mirrored, in the order MailChimp returned them.
+func CandidateCampaigns(conf Configuration, campaigns []MailChimpCampaign) []int {
+	var candidates []int
+	now := clock.Now()
+
+	for i, campaign := range campaigns {
+		if _, err := CampaignAge(conf, &campaign, now); errors.Is(err, errSendTimeInFuture) {
+			log.Printf("Excluding campaign %s, its send_time %s is in the future beyond ClockSkewToleranceSeconds", campaign.Id, campaign.SendTime)
+			continue
+		}
+		if conf.ExcludeTestCampaigns && campaign.IsTest(conf) {
+			log.Printf("Excluding campaign %s (%q) as a test send", campaign.Id, campaign.Settings.Title)
+			continue
+		}
+		if campaign.EmailsSent < conf.MinEmailsSent {
+			log.Printf("Excluding campaign %s, sent to %d emails which is below MinEmailsSent %d", campaign.Id, campaign.EmailsSent, conf.MinEmailsSent)
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+
+	return candidates
+}
+
+var errSendTimeInFuture = errors.New("send_time is in the future")
+
+// CampaignAge returns how long ago the campaign was sent. Host and MailChimp clocks
+// can disagree slightly, so a send_time up to ClockSkewToleranceSeconds in the future
+// counts as sent just now rather than breaking age-based rules.
+func CampaignAge(conf Configuration, campaign *MailChimpCampaign, now time.Time) (time.Duration, error) {
+	sendTime, err := time.Parse(time.RFC3339, campaign.SendTime)
+	if err != nil {
+		return 0, fmt.Errorf("campaign %s has no usable send_time: %w", campaign.Id, err)
+	}
+
+	age := now.Sub(sendTime)
+	if age < 0 {
+		if -age > time.Duration(conf.ClockSkewToleranceSeconds)*time.Second {
+			return 0, errSendTimeInFuture
+		}
+		age = 0
+	}
+
+	return age, nil
+}
+
+// IsTest reports whether the campaign title or subject matches TestCampaignPattern
+func (c *MailChimpCampaign) IsTest(conf Configuration) bool {
+	return conf.TestCampaignPattern.MatchString(c.Settings.Title) || conf.TestCampaignPattern.MatchString(c.Settings.SubjectLine)
+}
+
+// GetMailChimpCampaign fetches the full record of a single campaign
+func GetMailChimpCampaign(ctx context.Context, conf Configuration, result *Result, campaignId string) (*MailChimpCampaign, error) {
+	url := MailChimpApiUrl(conf, "/campaigns/"+campaignId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+	resp, err := SendRequest(conf, result, "mailchimp", conf.MailChimpOkStatuses, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	campaign := &MailChimpCampaign{}
+	if err := json.NewDecoder(resp.Body).Decode(campaign); err != nil {
+		return nil, err
+	}
+
+	return campaign, nil
+}
+
+// ArchiveContentHash fetches the campaign archive page and returns the hex SHA-256
+// of its body, for CompareMode=content-hash
+func ArchiveContentHash(ctx context.Context, conf Configuration, result *Result, archiveUrl string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", archiveUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := SendRequest(conf, result, "archive", DefaultOkStatuses, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CheckArchiveReachable requests the archive page with a HEAD request, all retries
+// included within ArchiveCheckTimeout so a slow archive host cannot hold up the run
+func CheckArchiveReachable(ctx context.Context, conf Configuration, result *Result, archiveUrl string) error {
+	if conf.ArchiveCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.ArchiveCheckTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", archiveUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := SendRequest(conf, result, "archive-check", DefaultOkStatuses, req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// PingMailChimp checks the API key and server prefix against MailChimp's ping endpoint
+func PingMailChimp(ctx context.Context, conf Configuration) error {
+	url := MailChimpApiUrl(conf, "/ping")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+
+	resp, err := SendRequest(conf, nil, "mailchimp", conf.MailChimpOkStatuses, req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// NewestCampaignIndex returns the index of the candidate with the latest send_time.
+// Campaigns without a parseable send_time are never preferred, and ties keep the
+// earliest position so the API's own order wins.
+func NewestCampaignIndex(campaigns []MailChimpCampaign, candidates []int) int {
+	newest := candidates[0]
+	var newestTime time.Time
+
+	for _, i := range candidates {
+		sendTime, err := time.Parse(time.RFC3339, campaigns[i].SendTime)
+		if err != nil {
+			continue
+		}
+		if newestTime.IsZero() || sendTime.After(newestTime) {
+			newest = i
+			newestTime = sendTime
+		}
+	}
+
+	return newest
+}
+
+// CandidatesBySendTime returns the candidates ordered newest first by send_time,
+// keeping the API order for ties and campaigns without a parseable send_time last
+func CandidatesBySendTime(campaigns []MailChimpCampaign, candidates []int) []int {
+	ordered := append([]int{}, candidates...)
+	sendTime := func(i int) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, campaigns[i].SendTime)
+		return parsed
+	}
+	sort.SliceStable(ordered, func(a, b int) bool {
+		return sendTime(ordered[a]).After(sendTime(ordered[b]))
+	})
+	return ordered
+}
+
+// TiedNewestCampaigns returns the ids of the candidates sharing the latest
+// send_time, and that send_time
+func TiedNewestCampaigns(campaigns []MailChimpCampaign, candidates []int) ([]string, string) {
+	newest := campaigns[NewestCampaignIndex(campaigns, candidates)].SendTime
+	newestTime, err := time.Parse(time.RFC3339, newest)
+	if err != nil {
+		return nil, ""
+	}
+
+	var tied []string
+	for _, i := range candidates {
+		if sendTime, err := time.Parse(time.RFC3339, campaigns[i].SendTime); err == nil && sendTime.Equal(newestTime) {
+			tied = append(tied, campaigns[i].Id)
+		}
+	}
+
+	return tied, newest
+}
+
+// campaignSensitiveFields are stripped from the campaign JSON before it is attached
+// to an email, as they contain addresses or audience details rather than content.
+var campaignSensitiveFields = map[string]bool{
+	"reply_to":   true,
+	"from_name":  true,
+	"to_name":    true,
+	"recipients": true,
+	"_links":     true,
+}
+
+// RedactedCampaignJson extracts the campaign at index from a /campaigns response body
+// and returns it as indented JSON with the sensitive fields removed.
+func RedactedCampaignJson(bodyBytes []byte, index int) []byte {
+	raw := struct {
+		Campaigns []map[string]interface{} `json:"campaigns"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, &raw); err != nil || index >= len(raw.Campaigns) {
+		return nil
+	}
+
+	campaign := redactFields(raw.Campaigns[index])
+
+	redacted, err := json.MarshalIndent(campaign, "", "  ")
+	if err != nil {
+		return nil
+	}
+
+	return redacted
+}
+
+func redactFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if campaignSensitiveFields[key] {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactFields(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactFields(child)
+		}
+	}
+
+	return value
+}
@@ -1,226 +1,795 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"github.com/joho/godotenv"
-	"io"
+	"golang.org/x/term"
 	"log"
+	"net"
 	"net/http"
-	"net/smtp"
+	"net/url"
 	"os"
+	"strings"
 )
 
-type Configuration struct {
-	SmtpHost              string
-	SmtpPort              string
-	SmtpUsername          string
-	SmtpPassword          string
-	SmtpFromEmail         string
-	SendEmailTo           string
-	MailChimpServerPrefix string
-	MailChimpApiKey       string
-	UrlDayLinkId          string
-	UrlDayApiKey          string
-}
-
-type UrlDay struct {
-	Status int `json:"status"`
-	Data   struct {
-		Id       string `json:"id"`
-		Alias    string `json:"alias"`
-		Url      string `json:"url"`
-		ShortUrl string `json:"short_url"`
-	} `json:"data"`
-}
-
-type MailChimpSent struct {
-	TotalItems int `json:"total_items"`
-	Campaigns  []struct {
-		Id             string `json:"id"`
-		ArchiveUrl     string `json:"archive_url"`
-		LongArchiveUrl string `json:"long_archive_url"`
-		Status         string `json:"status"`
-	} `json:"campaigns"`
+// LinkTarget is a UrlDay link kept in sync with one URL field of the latest campaign
+type LinkTarget struct {
+	Name     string
+	LinkId   string
+	UrlField string
 }
 
+// assumeYes skips the confirmation prompt before updating a link, see ConfirmUpdate
+var assumeYes bool
+
+// noCache forces every link to be read fresh, ignoring LinkCacheTtl
+var noCache bool
+
+// reconcile makes the state match the links as they are, without updating them
+var reconcile bool
+
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(RunValidateCommand())
+		case "smoke":
+			os.Exit(RunSmokeCommand())
+		case "setup":
+			os.Exit(RunSetupCommand())
+		case "config":
+			os.Exit(RunConfigCommand(os.Args[2:]))
+		case "notify-replay":
+			os.Exit(RunNotifyReplayCommand())
+		case "providers":
+			os.Exit(RunProvidersCommand())
+		case "campaigns":
+			os.Exit(RunCampaignsCommand(os.Args[2:]))
+		case "daemon":
+			os.Exit(RunDaemonCommand(os.Args[2:]))
+		default:
+			fatalf("Unknown command %q", os.Args[1])
+		}
+	}
+
+	flag.BoolVar(&assumeYes, "yes", false, "update links without asking, even when run from a terminal")
+	flag.BoolVar(&simulating, "simulate", false, "run against the real services without updating links or saving state, printing notifications instead of sending them")
+	flag.BoolVar(&noCache, "no-cache", false, "read every link fresh, ignoring LinkCacheTtl")
+	flag.BoolVar(&reconcile, "reconcile", false, "record what the links hold in the state without updating them")
+	jsonOutput := flag.Bool("json", false, "write the run result to stdout as JSON")
+	format := flag.String("format", "", "write the run result to stdout in this format: md for Markdown")
+	flag.Parse()
+
+	if *format != "" && *format != "md" {
+		fatalf("Unknown format %q, expected md", *format)
+	}
+	if *format != "" && *jsonOutput {
+		fatalf("-json and -format are mutually exclusive")
+	}
+
 	conf := ReadConfiguration()
+	if simulating {
+		log.Print("Simulating, links will not be updated and notifications are printed")
+		conf.ReadOnly = true
+	}
+	if reconcile {
+		conf.ReadOnly = true
+	}
+	ConfigureLogging(conf)
+	LogConfigKeys(conf)
+	if problems := conf.Validate(); len(problems) > 0 {
+		fatalf("Invalid configuration:\n%s", FormatProblems(problems))
+	}
 
-	currentUrlDay := GetCurrentUrlDay(conf)
-	currentMailchimpUrl := GetLatestMailChimpCampaignUrl(conf)
+	ConfigureHttpTransport(conf)
+	SetupTracing(conf)
 
-	logMessage := fmt.Sprintf("Current UrlDay: %s\r\nCurrent MailChimp: %s\r\n", currentUrlDay, currentMailchimpUrl)
+	result, err := RunOnce(conf)
+	shutdownTracing()
 
-	if currentUrlDay != currentMailchimpUrl {
-		logMessage = logMessage + "\tUpdate Required"
-		UpdateUrlDay(conf, currentMailchimpUrl)
-		logMessage = logMessage + "\r\n\tUpdate Successful"
-	} else {
-		logMessage = logMessage + "\tNO Update Required"
+	if result != nil {
+		if err := WriteResult(os.Stdout, result, *format, *jsonOutput); err != nil {
+			log.Printf("Could not write the run summary: %s", err)
+		}
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// RunOnce starts a new run, syncs within its trace span and pings the monitor
+func RunOnce(conf Configuration) (*Result, error) {
+	BeginRun()
+
+	ctx, stopWatchdog := StartWatchdog(context.Background(), conf)
+	defer stopWatchdog()
+
+	ctx, span := tracer.Start(ctx, "run")
+	result, err := RunSync(ctx, conf)
+	if err != nil && hardTimeoutExceeded.Load() {
+		err = fmt.Errorf("run exceeded hard timeout of %ds: %w", conf.HardTimeoutSeconds, err)
 	}
+	EndSpan(span, err)
 
-	SendGmailEmail(conf, "[ADMC][SUCCESS] MailChimp To Website Automation", logMessage)
+	PostResultWebhook(conf, result, err)
+	PingMonitor(conf, err == nil)
+	return result, err
 }
 
-func ReadConfiguration() Configuration {
-	conf := Configuration{}
+// RunSync performs a single sync of the UrlDay links against the latest campaign
+// and sends the success notification.
+func RunSync(ctx context.Context, conf Configuration) (*Result, error) {
+	result := NewResult()
+	result.RunId = runId
+	result.Started = clock.Now()
 
-	// Assumes there is a .env file in the directory you are executing from which contains:
-	/*
-		SmtpHost
-		SmtpPort
-		SmtpUsername
-		SmtpPassword
-		SmtpFromEmail
-		SendEmailTo
-		MailChimpServerPrefix
-		MailChimpApiKey
-		UrlDayLinkId
-		UrlDayApiKey
-	*/
-	err := godotenv.Load()
+	store := NewStateStore(conf)
+	state, err := store.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		HandleError(conf, StageError("state", err))
 	}
 
-	conf.SmtpHost = os.Getenv("SmtpHost")
-	conf.SmtpPort = os.Getenv("SmtpPort")
-	conf.SmtpUsername = os.Getenv("SmtpUsername")
-	conf.SmtpPassword = os.Getenv("SmtpPassword")
-	conf.SmtpFromEmail = os.Getenv("SmtpFromEmail")
-	conf.SendEmailTo = os.Getenv("SendEmailTo")
-	conf.MailChimpServerPrefix = os.Getenv("MailChimpServerPrefix")
-	conf.MailChimpApiKey = os.Getenv("MailChimpApiKey")
-	conf.UrlDayLinkId = os.Getenv("UrlDayLinkId")
-	conf.UrlDayApiKey = os.Getenv("UrlDayApiKey")
+	// With FirstRunMode=baseline the first run only records what it sees, so the
+	// initial deployment never pushes an unexpected update
+	result.Baseline = conf.FirstRunMode == "baseline" && state.IsFirstRun()
 
-	return conf
-}
+	// Everything below filters on the audience id, so a name is resolved up front
+	if conf.MailChimpListId, err = ResolveMailChimpListId(ctx, conf, result); err != nil {
+		HandleError(conf, StageError("mailchimp", err))
+	}
 
-func SendGmailEmail(conf Configuration, emailSubject string, emailBody string) {
+	campaign := GetLatestMailChimpCampaign(ctx, conf, result)
 
-	to := []string{conf.SendEmailTo} // TODO - split if comma separated
+	var history []MailChimpCampaign
+	if conf.TrackHistory {
+		history, err = FetchHistory(ctx, conf, result, state.HistoryCursor)
+		if err != nil {
+			HandleError(conf, StageError("mailchimp", err))
+		}
+	}
 
-	message := []byte("Subject: " + emailSubject + "\r\n\r\n" + emailBody)
+	// With ConfirmReads a second read must agree before anything is updated, so a
+	// momentarily inconsistent MailChimp response is never acted on
+	if conf.ConfirmReads && campaign != nil {
+		clock.Sleep(conf.ConfirmReadDelay)
 
-	// Create authentication
-	auth := smtp.PlainAuth("", conf.SmtpFromEmail, conf.SmtpPassword, conf.SmtpHost)
+		// Only the campaign of the second read matters, the result describes the first
+		scratch := NewResult()
+		confirmed := GetLatestMailChimpCampaign(ctx, conf, scratch)
+		for stage, retries := range scratch.Retries {
+			result.CountRetries(stage, retries)
+		}
+		if !SameCampaign(conf, campaign, confirmed) {
+			log.Printf("MailChimp reads disagreed on the latest campaign, not updating this run")
+			result.ReadsDisagreed = true
+		}
+	}
 
-	// Send actual message
-	err := smtp.SendMail(conf.SmtpHost+":"+conf.SmtpPort, auth, conf.SmtpFromEmail, to, message)
-	if err != nil {
-		log.Fatal(err)
+	if conf.CompareMode == "content-hash" && campaign != nil {
+		hash, err := ArchiveContentHash(ctx, conf, result, campaign.UrlField(conf.MailChimpUrlField))
+		if err != nil {
+			log.Printf("Could not hash the campaign archive, comparing URLs instead: %s", err)
+		}
+		result.LatestContentHash = hash
+	}
+
+	service := NewLinkService(conf)
+
+	for _, target := range LinkTargets(conf) {
+		result.Links = append(result.Links, SyncLink(ctx, conf, result, service, state, campaign, target))
+	}
+	ApplyLinkUpdates(ctx, conf, result, service, result.Links)
+
+	// An older campaign being mirrored because the newest was too small would
+	// otherwise go unnoticed, so the first run to skip a campaign notifies about it
+	smallCampaignSkipped := result.SmallCampaignId != "" && result.SmallCampaignId != state.LastSmallCampaignId
+	if smallCampaignSkipped {
+		log.Printf("Newest campaign %s is below MinEmailsSent, notifying that an earlier campaign is mirrored instead", result.SmallCampaignId)
+		state.LastSmallCampaignId = result.SmallCampaignId
+	}
+
+	synced := true
+	for _, link := range result.Links {
+		if link.UpdateRequired && !link.Updated {
+			synced = false
+		}
+	}
+
+	if result.Baseline {
+		state.BaselineCampaignId = result.LatestCampaignId
+		state.BaselineUrl = result.Primary().CurrentUrl
+	} else if reconcile {
+		// The state is made to match the link as it is, even if that is not the latest
+		primary := result.Primary()
+		log.Printf("Reconciling state to the %s link: %s", primary.Name, primary.CurrentUrl)
+		state.LastUrl = primary.CurrentUrl
+		if primary.CurrentUrl == primary.LatestUrl {
+			state.LastCampaignId = result.LatestCampaignId
+			state.LastWebId = result.LatestWebId
+			state.LastContentHash = result.LatestContentHash
+		}
+	} else if synced {
+		state.LastCampaignId = result.LatestCampaignId
+		state.LastUrl = result.Primary().LatestUrl
+		state.LastWebId = result.LatestWebId
+		state.LastContentHash = result.LatestContentHash
+	} else if result.Primary().DriftedFrom != "" {
+		state.LastUrl = result.Primary().CurrentUrl
+	}
+
+	if conf.TrackShortUrl {
+		if state.ShortUrls == nil {
+			state.ShortUrls = map[string]string{}
+		}
+		for linkId, shortUrl := range result.ShortUrls {
+			state.ShortUrls[linkId] = shortUrl
+		}
+	}
+
+	for _, link := range result.Links {
+		if link.RecreatedFrom != "" {
+			if state.RecreatedLinkIds == nil {
+				state.RecreatedLinkIds = map[string]string{}
+			}
+			state.RecreatedLinkIds[link.RecreatedFrom] = link.LinkId
+		}
+	}
+
+	if conf.LinkCacheTtl > 0 && conf.FeatureEnabled("cache") {
+		if state.LinkCache == nil {
+			state.LinkCache = map[string]CachedLink{}
+		}
+		for _, link := range result.Links {
+			// An updated link is re-read next run rather than trusted from the cache
+			if link.Updated {
+				delete(state.LinkCache, link.LinkId)
+			} else if !link.Cached {
+				state.LinkCache[link.LinkId] = CachedLink{Url: link.CurrentUrl, ReadAt: link.ReadAt}
+			}
+		}
+	}
+
+	// The cursor only moves once everything before this point succeeded
+	state.AddHistory(conf, history)
+
+	result.Recovered = state.LastStatus == StatusError
+
+	state.Initialized = true
+	state.LastStatus = StatusOk
+	if err := store.Save(state); err != nil {
+		HandleError(conf, StageError("state", err))
+	}
+
+	var attachments []EmailAttachment
+	if conf.AttachCampaignJson && result.LatestCampaignJson != nil {
+		attachments = append(attachments, EmailAttachment{
+			Filename:    "campaign.json",
+			ContentType: "application/json",
+			Data:        result.LatestCampaignJson,
+		})
+	}
+	if conf.AttachScreenshots && result.AnyUpdated() {
+		attachments = append(attachments, CaptureScreenshots(ctx, conf, result)...)
+	}
+
+	result.Duration = clock.Now().Sub(result.Started)
+
+	level := NotifyLevelInfo
+	if result.AnyUpdated() || result.ShortUrlChanged() || result.Primary().DriftedFrom != "" || smallCampaignSkipped {
+		level = NotifyLevelChange
+	}
+
+	// In transition mode a steady successful run is not worth a notification, only
+	// a recovery from a failing state or an actual change is
+	messages := LoadMessages(conf.NotifyLanguage)
+
+	subject := messages.Format("subject_success")
+	if result.Recovered {
+		subject = messages.Format("subject_recovered")
+	} else if conf.NotifyOnTransition && level == NotifyLevelInfo {
+		log.Print("No state transition, skipping notification")
+		return result, nil
+	}
+
+	summary := result.Summary(messages)
+	if journal != nil {
+		journal.Send(priorityNotice, summary, map[string]string{"OUTCOME": result.Outcome(), "CAMPAIGN_ID": result.LatestCampaignId})
 	}
+
+	return result, NotifyAll(ctx, conf, Notification{
+		Subject:     subject,
+		Body:        summary,
+		Success:     true,
+		Level:       level,
+		Outcome:     result.Outcome(),
+		Result:      result,
+		Attachments: attachments,
+	})
 }
 
-func GetCurrentUrlDay(conf Configuration) string {
-	url := "https://www.urlday.com/api/v1/links/" + conf.UrlDayLinkId
+// LinkTargets lists the links to keep in sync, the primary link first
+func LinkTargets(conf Configuration) []LinkTarget {
+	primaryId, previewId := conf.UrlDayLinkId, conf.UrlDayPreviewLinkId
+	switch conf.LinkProvider {
+	case "s3":
+		primaryId, previewId = conf.S3Key, conf.S3PreviewKey
+	case "webhook":
+		primaryId, previewId = conf.WebhookUrl, conf.WebhookPreviewUrl
+	}
 
-	client := &http.Client{}
+	targets := []LinkTarget{{Name: "primary", LinkId: primaryId, UrlField: conf.MailChimpUrlField}}
 
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+	if previewId != "" {
+		targets = append(targets, LinkTarget{Name: "preview", LinkId: previewId, UrlField: conf.MailChimpPreviewUrlField})
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		HandleError(conf, err)
+	return targets
+}
+
+// SyncLink compares a single link against the campaign and leaves it pending for
+// ApplyLinkUpdates if it needs an update
+func SyncLink(ctx context.Context, conf Configuration, result *Result, service LinkService, state State, campaign *MailChimpCampaign, target LinkTarget) *LinkResult {
+	link := &LinkResult{Name: target.Name, LinkId: target.LinkId}
+	started := clock.Now()
+	defer func() { link.Duration = clock.Now().Sub(started) }()
+
+	// A link recreated by RecreateMissingLink lives on under its new id, but its
+	// configuration, such as LinkTransformTemplates, stays under the configured one
+	configured := target
+	configuredId := target.LinkId
+	if id, ok := state.RecreatedLinkIds[configuredId]; ok {
+		Debugf("Using recreated id %s for %s link %s", id, link.Name, configuredId)
+		target.LinkId, link.LinkId = id, id
+	}
+
+	if campaign != nil {
+		link.LatestUrl = campaign.UrlField(target.UrlField)
+	}
+	if conf.StripTrackingParams {
+		link.LatestUrl = WithoutTrackingParams(link.LatestUrl)
+	}
+
+	// Each link can carry its own tracking parameters, so the transformed URL is what
+	// gets compared and pushed. The parameters the transform adds are always compared,
+	// or a changed utm_campaign would never reach the link with IgnoreTrackingParams.
+	var transformParams []string
+	if template := LinkTransformTemplate(conf, configured); template != "" && link.LatestUrl != "" {
+		link.UntransformedUrl = link.LatestUrl
+		link.LatestUrl = ApplyUrlTransform(template, link.LatestUrl, result, target)
+		transformParams = addedTrackingParams(link.UntransformedUrl, link.LatestUrl)
+		Debugf("Transformed %s link URL %s to %s", link.Name, link.UntransformedUrl, link.LatestUrl)
 	}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+	// Within LinkCacheTtl of the last read the cached URL is used instead, saving an
+	// API call per link on frequent daemon runs
+	if cachedUrl, ok := state.CachedLinkUrl(target.LinkId, conf.LinkCacheTtl); ok && !noCache && conf.FeatureEnabled("cache") {
+		Debugf("Using the cached URL of %s link read at %s", link.Name, state.LinkCache[target.LinkId].ReadAt)
+		link.CurrentUrl = cachedUrl
+		link.Cached = true
+	} else {
+		currentUrl, err := service.GetURL(ctx, conf, result, target.LinkId)
+		err = StageError(conf.LinkProvider+"-get", err)
+		if IsStatusError(err, http.StatusNotFound) && conf.RecreateMissingLink && !conf.ReadOnly && link.LatestUrl != "" {
+			currentUrl, err = RecreateMissingLink(ctx, conf, result, link, configuredId)
+			err = StageError(conf.LinkProvider+"-create", err)
+		}
 		if err != nil {
 			HandleError(conf, err)
 		}
-	}(resp.Body)
+		link.CurrentUrl = currentUrl
+		link.ReadAt = clock.Now()
+	}
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	// The link should still hold what the last sync put there, or it was edited
+	// outside of this tool. The state is reconciled to what the link really holds.
+	if target.Name == "primary" && !link.Cached && link.RecreatedFrom == "" && state.LastUrl != "" && !urlsEqual(conf, link.CurrentUrl, state.LastUrl, transformParams...) {
+		log.Printf("Warning: drift detected, %s link holds %s but the state recorded %s", link.Name, link.CurrentUrl, state.LastUrl)
+		link.DriftedFrom = state.LastUrl
+	}
 
-	// Convert response body to UrlDay struct
-	urlday := UrlDay{}
-	err = json.Unmarshal(bodyBytes, &urlday)
+	// A different short URL for the same link id means the link was recreated
+	// rather than edited, which the target URL alone would not show
+	if conf.TrackShortUrl {
+		link.ShortUrl = result.ShortUrls[target.LinkId]
+		if previous := state.ShortUrls[target.LinkId]; previous != "" && link.ShortUrl != "" && previous != link.ShortUrl {
+			log.Printf("Warning: short URL of %s link changed from %s to %s", link.Name, previous, link.ShortUrl)
+			link.PreviousShortUrl = previous
+		}
+	}
+
+	link.UpdateRequired = !urlsEqual(conf, link.CurrentUrl, link.LatestUrl, transformParams...)
+
+	// Two empty URLs compare equal, which would otherwise look like a link already in
+	// sync on a fresh account
+	if link.CurrentUrl == "" && link.LatestUrl == "" {
+		log.Printf("Nothing to sync yet for %s link: no campaign to mirror and the link has no URL. It is set once a campaign is sent; if one was, check MailChimpListId and the campaign filters", link.Name)
+		link.NothingToSync = true
+	}
+
+	// A link stored as a short URL that redirects to the campaign would otherwise
+	// differ on every run, so with CompareResolvedRedirect its first hop is compared
+	if link.UpdateRequired && conf.CompareResolvedRedirect && link.CurrentUrl != "" && link.LatestUrl != "" {
+		resolved, err := ResolveRedirect(ctx, conf, link.CurrentUrl)
+		if err != nil {
+			log.Printf("Could not resolve %s link target %s, comparing it as stored: %s", link.Name, link.CurrentUrl, err)
+		} else if urlsEqual(conf, resolved, link.LatestUrl, transformParams...) {
+			log.Printf("%s link target %s redirects to %s, no update required", link.Name, link.CurrentUrl, resolved)
+			link.UpdateRequired = false
+		}
+	}
+
+	// Providers may rewrite the stored URL, so with CompareMode=web_id a URL difference
+	// is ignored while the campaign web_id is the one already synced. A link edited
+	// elsewhere is still repaired, and a link already equal is never rewritten.
+	if conf.CompareMode == "web_id" && state.LastWebId != 0 && result.LatestWebId != 0 &&
+		result.LatestWebId == state.LastWebId && link.UpdateRequired && link.DriftedFrom == "" {
+		log.Printf("Campaign web_id %d unchanged, ignoring URL difference on %s link", result.LatestWebId, link.Name)
+		link.UpdateRequired = false
+	}
+
+	// With CompareMode=content-hash the archive page content is the change signal,
+	// which also catches MailChimp reusing a URL for changed content
+	if conf.CompareMode == "content-hash" && state.LastContentHash != "" && result.LatestContentHash != "" {
+		if result.LatestContentHash == state.LastContentHash && link.UpdateRequired {
+			log.Printf("Campaign archive content unchanged, ignoring URL difference on %s link", link.Name)
+		}
+		link.UpdateRequired = result.LatestContentHash != state.LastContentHash
+	}
+
+	// MailChimp can flip the archive URL scheme between calls for the same campaign,
+	// so once a campaign has been synced a scheme-only difference is not a change.
+	if link.UpdateRequired && conf.IgnoreSchemeForSameCampaign &&
+		result.LatestCampaignId != "" && result.LatestCampaignId == state.LastCampaignId &&
+		UrlsEqualIgnoringScheme(link.CurrentUrl, link.LatestUrl) {
+		log.Printf("Campaign %s already synced to %s link, ignoring scheme-only difference", result.LatestCampaignId, link.Name)
+		link.UpdateRequired = false
+	}
+
+	// After a baseline run the baseline campaign is treated as already mirrored
+	if link.UpdateRequired && !result.Baseline &&
+		result.LatestCampaignId != "" && result.LatestCampaignId == state.BaselineCampaignId {
+		log.Printf("Campaign %s was recorded as the baseline, not mirroring it to %s link", result.LatestCampaignId, link.Name)
+		link.UpdateRequired = false
+	}
+
+	if link.UpdateRequired && result.Baseline {
+		log.Printf("First run in baseline mode, recording %s link without updating", link.Name)
+		link.SkippedReason = "baseline run, FirstRunMode=baseline"
+	} else if link.UpdateRequired && conf.ReadOnly {
+		log.Printf("ReadOnly, would have updated %s link from %s to %s", link.Name, link.CurrentUrl, link.LatestUrl)
+		link.SkippedReason = "ReadOnly"
+	} else if link.UpdateRequired && result.NoCampaignReason != "" {
+		link.SkippedReason = result.NoCampaignReason
+	} else if link.UpdateRequired && link.LatestUrl == "" {
+		// Pushing an empty URL would wipe the live link
+		log.Printf("Campaign has no %s, not clearing %s link", target.UrlField, link.Name)
+		link.SkippedReason = "campaign has no " + target.UrlField
+	} else if link.UpdateRequired && result.ReadsDisagreed {
+		link.SkippedReason = "MailChimp reads disagreed, ConfirmReads"
+	} else if link.UpdateRequired && !conf.UpdateSchedule.Allows(clock.Now()) {
+		log.Printf("Update of %s link required but outside UpdateSchedule %q, skipping", link.Name, conf.UpdateSchedule)
+		link.SkippedReason = "outside UpdateSchedule " + conf.UpdateSchedule.String()
+	} else if link.UpdateRequired && !ConfirmUpdate(link) {
+		log.Printf("Update of %s link declined", link.Name)
+		link.SkippedReason = "declined at the prompt"
+	} else if link.UpdateRequired && !ArchiveReadyForUpdate(ctx, conf, result, link) {
+		log.Printf("Update of %s link skipped, %s", link.Name, link.SkippedReason)
+	} else if link.UpdateRequired {
+		link.pending = true
+	}
+
+	return link
+}
+
+// ApplyLinkUpdates pushes the latest URL to every link SyncLink left pending, in a
+// single request where the provider supports it, then verifies each of them
+func ApplyLinkUpdates(ctx context.Context, conf Configuration, result *Result, service LinkService, links []*LinkResult) {
+	var pending []*LinkResult
+	var updates []LinkUpdate
+	for _, link := range links {
+		if link.pending {
+			pending = append(pending, link)
+			updates = append(updates, LinkUpdate{LinkId: link.LinkId, Url: link.LatestUrl})
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	started := clock.Now()
+	if err := UpdateLinks(ctx, conf, result, service, updates); err != nil {
+		HandleError(conf, StageError(conf.LinkProvider+"-put", err))
+	}
+	elapsed := clock.Now().Sub(started)
+
+	for _, link := range pending {
+		link.pending = false
+		link.Updated = true
+		link.Duration += elapsed
+
+		if !conf.VerifyUpdate {
+			link.VerificationSkipped, link.VerificationSkippedReason = true, "VerifyUpdate=false"
+		} else if !conf.FeatureEnabled("verify") {
+			link.VerificationSkipped, link.VerificationSkippedReason = true, "Features=-verify"
+		} else {
+			if err := VerifyLinkUpdate(ctx, conf, result, service, link.LinkId, link.LatestUrl); err != nil {
+				HandleError(conf, StageError(conf.LinkProvider+"-verify", err))
+			}
+			link.Verified = true
+		}
+	}
+}
+
+// RecreateMissingLink creates a UrlDay link for the campaign URL with
+// RecreateLinkAlias after the configured one returned 404, so an accidentally
+// deleted link heals itself. The new id is kept in the state for later runs.
+func RecreateMissingLink(ctx context.Context, conf Configuration, result *Result, link *LinkResult, configuredId string) (string, error) {
+	if conf.LinkProvider != "urlday" {
+		return "", fmt.Errorf("%s link %s not found, RecreateMissingLink only supports LinkProvider=urlday", link.Name, configuredId)
+	}
+
+	log.Printf("Warning: %s link %s not found, recreating it for %s", link.Name, link.LinkId, link.LatestUrl)
+	id, err := CreateUrlDayLink(ctx, conf, result, link.LatestUrl, conf.RecreateLinkAlias)
 	if err != nil {
-		HandleError(conf, err)
+		return "", fmt.Errorf("%s link %s not found and recreating it failed: %w", link.Name, link.LinkId, err)
 	}
 
-	return urlday.Data.Url
+	log.Printf("Recreated %s link %s as %s", link.Name, configuredId, id)
+	link.LinkId = id
+	link.RecreatedFrom = configuredId
+	link.Updated = true
+	return link.LatestUrl, nil
 }
 
-func UpdateUrlDay(conf Configuration, urlUpdate string) {
+// ArchiveReadyForUpdate runs the VerifyArchiveReachable check before the link is
+// pointed at the campaign archive, reporting whether to go ahead. An unreachable
+// archive skips the update. A check that times out follows ArchiveCheckTimeoutPolicy:
+// skip goes ahead and notes the archive as unverified, fail fails the run.
+func ArchiveReadyForUpdate(ctx context.Context, conf Configuration, result *Result, link *LinkResult) bool {
+	if !conf.VerifyArchiveReachable {
+		return true
+	}
+
+	err := CheckArchiveReachable(ctx, conf, result, link.LatestUrl)
+	if err == nil {
+		return true
+	}
 
-	newUrlInfo := fmt.Sprintf("url=%s", urlUpdate)
+	var netErr net.Error
+	timedOut := errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	if !timedOut {
+		link.SkippedReason = "campaign archive not reachable: " + err.Error()
+		return false
+	}
 
-	url := "https://www.urlday.com/api/v1/links/" + conf.UrlDayLinkId
-	client := &http.Client{}
+	if conf.ArchiveCheckTimeoutPolicy == "fail" {
+		HandleError(conf, StageError("archive-check", fmt.Errorf("campaign archive %s reachability check timed out after %s: %w", link.LatestUrl, conf.ArchiveCheckTimeout, err)))
+	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer([]byte(newUrlInfo)))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+	log.Printf("Campaign archive %s reachability check timed out after %s, updating anyway", link.LatestUrl, conf.ArchiveCheckTimeout)
+	link.ArchiveUnverified = true
+	return true
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		HandleError(conf, err)
+// SameCampaign reports whether two reads returned the same campaign with the same
+// URLs for every link target
+func SameCampaign(conf Configuration, a *MailChimpCampaign, b *MailChimpCampaign) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Id != b.Id {
+		return false
 	}
+	for _, target := range LinkTargets(conf) {
+		if a.UrlField(target.UrlField) != b.UrlField(target.UrlField) {
+			return false
+		}
+	}
+	return true
+}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			HandleError(conf, err)
+// ConfirmUpdate asks before a link is changed when run from a terminal, so a manual
+// run cannot update anything by accident. Unattended runs and -yes always proceed.
+func ConfirmUpdate(link *LinkResult) bool {
+	if assumeYes || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return true
+	}
+
+	return promptYesNo(stdinReader, fmt.Sprintf("Update %s link %s from %s to %s?", link.Name, link.LinkId, link.CurrentUrl, link.LatestUrl))
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// urlsEqual compares a link URL with a campaign URL. Hosts are case insensitive, so
+// with CompareHostCaseInsensitive a provider lowercasing the host is no difference,
+// and ComparePathCaseInsensitive extends that to the path for servers known to
+// treat it that way. With IgnoreTrackingParams the utm_* and mc_* parameters
+// MailChimp varies between sends are no difference either, apart from the names in
+// keep. Anything that does not parse is compared exactly.
+func urlsEqual(conf Configuration, a string, b string, keep ...string) bool {
+	if a == b {
+		return true
+	}
+	if !conf.CompareHostCaseInsensitive && !conf.ComparePathCaseInsensitive && !conf.IgnoreTrackingParams {
+		return false
+	}
+
+	parsedA, errA := url.Parse(a)
+	parsedB, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	for _, parsed := range []*url.URL{parsedA, parsedB} {
+		if conf.CompareHostCaseInsensitive {
+			parsed.Host = strings.ToLower(parsed.Host)
 		}
-	}(resp.Body)
+		if conf.ComparePathCaseInsensitive {
+			parsed.Path = strings.ToLower(parsed.Path)
+			parsed.RawPath = strings.ToLower(parsed.RawPath)
+		}
+		if conf.IgnoreTrackingParams {
+			parsed.RawQuery = withoutTrackingQuery(parsed.RawQuery, keep...)
+		}
+	}
 
-	if resp.StatusCode != 200 {
-		e := errors.New("issue with UrlDay update, response status not 200")
-		HandleError(conf, e)
+	return parsedA.String() == parsedB.String()
+}
+
+// WithoutTrackingParams removes the utm_* and mc_* query parameters from rawUrl,
+// keeping the other parameters in their order. A URL that does not parse is
+// returned unchanged.
+func WithoutTrackingParams(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.RawQuery == "" {
+		return rawUrl
 	}
 
+	parsed.RawQuery = withoutTrackingQuery(parsed.RawQuery)
+	parsed.ForceQuery = false
+	return parsed.String()
 }
 
-func GetLatestMailChimpCampaignUrl(conf Configuration) string {
-	url := fmt.Sprintf("https://%s.api.mailchimp.com/3.0/campaigns?status=sent&sort_field=send_time&sort_dir=DESC&count=1", conf.MailChimpServerPrefix)
+// withoutTrackingQuery removes the tracking parameters from rawQuery, apart from
+// the names in keep
+func withoutTrackingQuery(rawQuery string, keep ...string) string {
+	keepNames := map[string]bool{}
+	for _, name := range keep {
+		keepNames[strings.ToLower(name)] = true
+	}
 
-	client := &http.Client{}
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if name := queryParamName(param); isTrackingParam(name) && !keepNames[name] {
+			continue
+		}
+		kept = append(kept, param)
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	req.SetBasicAuth("anystring", conf.MailChimpApiKey)
+	return strings.Join(kept, "&")
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		HandleError(conf, err)
+// addedTrackingParams lists the tracking parameters in transformed that are not in
+// original, the ones a link transform template added
+func addedTrackingParams(original string, transformed string) []string {
+	parsedOriginal, errOriginal := url.Parse(original)
+	parsedTransformed, errTransformed := url.Parse(transformed)
+	if errOriginal != nil || errTransformed != nil || parsedTransformed.RawQuery == "" {
+		return nil
 	}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			HandleError(conf, err)
+	seen := map[string]bool{}
+	for _, param := range strings.Split(parsedOriginal.RawQuery, "&") {
+		seen[queryParamName(param)] = true
+	}
+
+	var added []string
+	for _, param := range strings.Split(parsedTransformed.RawQuery, "&") {
+		if name := queryParamName(param); isTrackingParam(name) && !seen[name] {
+			seen[name] = true
+			added = append(added, name)
 		}
-	}(resp.Body)
+	}
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	return added
+}
 
-	//tempBodyString := string(bodyBytes)
-	//fmt.Print(tempBodyString)
+// queryParamName is the lowercased name of a name=value query parameter
+func queryParamName(param string) string {
+	return strings.ToLower(strings.SplitN(param, "=", 2)[0])
+}
 
-	// Convert response body to MailChimpSent struct
-	mailchimpSent := MailChimpSent{}
-	err = json.Unmarshal(bodyBytes, &mailchimpSent)
-	if err != nil {
-		HandleError(conf, err)
+func isTrackingParam(name string) bool {
+	return strings.HasPrefix(name, "utm_") || strings.HasPrefix(name, "mc_")
+}
+
+// UrlsEqualIgnoringScheme compares two URLs while treating http and https as equal
+func UrlsEqualIgnoringScheme(a string, b string) bool {
+	parsedA, errA := url.Parse(a)
+	parsedB, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
 	}
 
-	currentUrl := ""
-	if len(mailchimpSent.Campaigns) == 1 {
-		currentUrl = mailchimpSent.Campaigns[0].LongArchiveUrl
+	parsedA.Scheme = ""
+	parsedB.Scheme = ""
+
+	return parsedA.String() == parsedB.String()
+}
+
+// StageError prefixes err with the stage of the run it happened in, such as
+// mailchimp, urlday-get, urlday-put or notify, so logs and notifications show where
+// a run failed. The error stays available to errors.Is and errors.As.
+func StageError(stage string, err error) error {
+	if err == nil {
+		return nil
 	}
+	return &stageError{stage: stage, err: err}
+}
+
+type stageError struct {
+	stage string
+	err   error
+}
 
-	return currentUrl
+func (e *stageError) Error() string {
+	return e.stage + ": " + e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// ErrorStage returns the stage StageError attached to err, or "" for none
+func ErrorStage(err error) string {
+	var staged *stageError
+	if errors.As(err, &staged) {
+		return staged.stage
+	}
+	return ""
 }
 
 func HandleError(conf Configuration, e error) {
-	SendGmailEmail(conf, "[ADMC][ERROR] with MailChimp to Website Automation", "Error Message: "+e.Error())
-	log.Fatal(e)
+	if hardTimeoutExceeded.Load() {
+		e = fmt.Errorf("run exceeded hard timeout of %ds: %w", conf.HardTimeoutSeconds, e)
+	}
+
+	// Record the failure so the next successful run can report the recovery. In
+	// transition mode only the first failure of an incident is notified.
+	alreadyFailing := false
+	store := NewStateStore(conf)
+	if state, err := store.Load(); err == nil {
+		alreadyFailing = state.LastStatus == StatusError
+		state.LastStatus = StatusError
+		if err := store.Save(state); err != nil {
+			log.Printf("Could not record error state: %s", err)
+		}
+	}
+
+	if conf.NotifyOnTransition && alreadyFailing {
+		log.Print("Still failing since the previous run, skipping notification")
+	} else {
+		messages := LoadMessages(conf.NotifyLanguage)
+		_ = NotifyAll(context.Background(), conf, Notification{
+			Subject: messages.Format("subject_error"),
+			Body:    messages.Format("error_message", e.Error()) + "\r\n\r\n" + messages.Format("run_id", runId),
+			Level:   NotifyLevelError,
+			Outcome: OutcomeError,
+		})
+	}
+
+	PostResultWebhook(conf, nil, e)
+
+	// The daemon recovers this and schedules the next run, see RunDaemonIteration
+	if daemonMode {
+		panic(runAborted{err: e})
+	}
+
+	shutdownTracing()
+	PingMonitor(conf, false)
+	fatal(e)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUrlsEqual(t *testing.T) {
+	host := Configuration{CompareHostCaseInsensitive: true}
+	path := Configuration{ComparePathCaseInsensitive: true}
+	tracking := Configuration{IgnoreTrackingParams: true}
+
+	tests := []struct {
+		name string
+		conf Configuration
+		a, b string
+		keep []string
+		want bool
+	}{
+		{name: "identical", a: "https://example.com/a?x=1", b: "https://example.com/a?x=1", want: true},
+		{name: "unparseable identical", conf: tracking, a: "https://example.com/%zz", b: "https://example.com/%zz", want: true},
+		{name: "unparseable", conf: tracking, a: "https://example.com/%zz?utm_source=a", b: "https://example.com/%zz", want: false},
+
+		{name: "host case off", a: "https://Example.COM/a", b: "https://example.com/a", want: false},
+		{name: "host case on", conf: host, a: "https://Example.COM/a", b: "https://example.com/a", want: true},
+		{name: "host case on keeps path", conf: host, a: "https://Example.COM/A", b: "https://example.com/a", want: false},
+
+		{name: "path case off", a: "https://example.com/Campaign", b: "https://example.com/campaign", want: false},
+		{name: "path case on", conf: path, a: "https://example.com/Campaign", b: "https://example.com/campaign", want: true},
+		{name: "path case on keeps host", conf: path, a: "https://EXAMPLE.com/Campaign", b: "https://example.com/campaign", want: false},
+
+		{name: "tracking off", a: "https://example.com/a?utm_source=x", b: "https://example.com/a", want: false},
+		{name: "tracking on", conf: tracking, a: "https://example.com/a?utm_source=x&mc_cid=1", b: "https://example.com/a", want: true},
+		{name: "tracking mixed case", conf: tracking, a: "https://example.com/a?UTM_Source=x&Mc_Eid=2", b: "https://example.com/a", want: true},
+		{name: "tracking keeps other params", conf: tracking, a: "https://example.com/a?id=1&utm_source=x", b: "https://example.com/a?id=2", want: false},
+		{name: "tracking keeps param order", conf: tracking, a: "https://example.com/a?x=1&utm_source=s&y=2", b: "https://example.com/a?x=1&y=2", want: true},
+		{name: "tracking reordered params differ", conf: tracking, a: "https://example.com/a?y=2&x=1", b: "https://example.com/a?x=1&y=2", want: false},
+		{name: "tracking keep differs", conf: tracking, a: "https://example.com/a?utm_campaign=old", b: "https://example.com/a?utm_campaign=new", keep: []string{"utm_campaign"}, want: false},
+		{name: "tracking keep is case insensitive", conf: tracking, a: "https://example.com/a?UTM_Campaign=old", b: "https://example.com/a?utm_campaign=new", keep: []string{"utm_campaign"}, want: false},
+		{name: "tracking keep ignores others", conf: tracking, a: "https://example.com/a?utm_campaign=x&mc_cid=1", b: "https://example.com/a?utm_campaign=x&mc_cid=2", keep: []string{"utm_campaign"}, want: true},
+	}
+
+	for _, test := range tests {
+		if got := urlsEqual(test.conf, test.a, test.b, test.keep...); got != test.want {
+			t.Errorf("%s: urlsEqual(%s, %s) = %t, want %t", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestWithoutTrackingParams(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://example.com/a", want: "https://example.com/a"},
+		{url: "https://example.com/a?x=1&utm_source=s&y=2&mc_cid=c", want: "https://example.com/a?x=1&y=2"},
+		{url: "https://example.com/a?UTM_Medium=m&Mc_Eid=e&id=3", want: "https://example.com/a?id=3"},
+		{url: "https://example.com/a?utm_source=s&mc_cid=c", want: "https://example.com/a"},
+		{url: "https://example.com/a?utm_source=s#top", want: "https://example.com/a#top"},
+		{url: "https://example.com/%zz?utm_source=s", want: "https://example.com/%zz?utm_source=s"},
+	}
+
+	for _, test := range tests {
+		if got := WithoutTrackingParams(test.url); got != test.want {
+			t.Errorf("WithoutTrackingParams(%s) = %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+func TestAddedTrackingParams(t *testing.T) {
+	tests := []struct {
+		original, transformed string
+		want                  []string
+	}{
+		{original: "https://example.com/a", transformed: "https://example.com/a", want: nil},
+		{original: "https://example.com/a?mc_cid=1", transformed: "https://example.com/a?mc_cid=1&UTM_Source=site&utm_source=again&x=1", want: []string{"utm_source"}},
+		{original: "https://example.com/a?utm_source=s", transformed: "https://example.com/a?utm_source=s&utm_medium=m", want: []string{"utm_medium"}},
+		{original: "https://example.com/a", transformed: "https://track.example.com/?to=https%3A%2F%2Fexample.com%2Fa&utm_campaign=c", want: []string{"utm_campaign"}},
+		{original: "https://example.com/a", transformed: "https://example.com/%zz?utm_source=s", want: nil},
+	}
+
+	for _, test := range tests {
+		if got := addedTrackingParams(test.original, test.transformed); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("addedTrackingParams(%s, %s) = %v, want %v", test.original, test.transformed, got, test.want)
+		}
+	}
+}
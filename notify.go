@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification is what gets sent to every configured Notifier at the end of a run
+type Notification struct {
+	Subject     string
+	Body        string
+	Success     bool
+	Level       NotifyLevel
+	Outcome     string
+	Result      *Result // nil when the run failed before producing one
+	Attachments []EmailAttachment
+	Time        time.Time
+}
+
+// Outcome values, each with its own optional body template
+const (
+	OutcomeUpdated = "updated"
+	OutcomeNoop    = "no-op"
+	OutcomePartial = "partial"
+	OutcomeError   = "error"
+)
+
+// OutcomeTemplate returns the NotifyTemplate* file configured for outcome, if any
+func OutcomeTemplate(conf Configuration, outcome string) string {
+	switch outcome {
+	case OutcomeUpdated:
+		return conf.NotifyTemplateUpdated
+	case OutcomeNoop:
+		return conf.NotifyTemplateNoop
+	case OutcomePartial:
+		return conf.NotifyTemplatePartial
+	case OutcomeError:
+		return conf.NotifyTemplateError
+	}
+	return ""
+}
+
+// ApplyOutcomeTemplate replaces the body with the template for the notification
+// outcome, filled with the same {{placeholders}} as SlackBlockTemplate plus
+// {{outcome}} and {{run_id}}. Without a template, or if it cannot be read, the
+// default body is kept.
+func ApplyOutcomeTemplate(conf Configuration, notification *Notification) {
+	path := OutcomeTemplate(conf, notification.Outcome)
+	if path == "" {
+		return
+	}
+
+	template, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Could not read %s notification template, using the default body: %s", notification.Outcome, err)
+		return
+	}
+
+	values := SlackTemplateValues(conf, *notification)
+	values["outcome"] = notification.Outcome
+	values["run_id"] = runId
+
+	replacements := []string{}
+	for name, value := range values {
+		replacements = append(replacements, "{{"+name+"}}", value)
+	}
+
+	notification.Body = strings.NewReplacer(replacements...).Replace(string(template))
+}
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, conf Configuration, notification Notification) error
+}
+
+// ConfiguredNotifiers returns the notifiers enabled by the configuration. Email is
+// always enabled, the others when their settings are present.
+func ConfiguredNotifiers(conf Configuration) []Notifier {
+	var notifiers []Notifier
+	for _, provider := range notifierProviders {
+		if provider.Enabled(conf) {
+			notifiers = append(notifiers, provider.New(conf))
+		}
+	}
+
+	return notifiers
+}
+
+// NotifyAll sends the notification through every configured notifier, logging each
+// failure. The returned error joins all failures, or is nil if every one succeeded.
+func NotifyAll(ctx context.Context, conf Configuration, notification Notification) error {
+	if notification.Time.IsZero() {
+		notification.Time = clock.Now()
+	}
+	ApplyOutcomeTemplate(conf, &notification)
+
+	limiter := LoadNotificationLimiter(conf)
+	defer limiter.Save()
+
+	type dispatch struct {
+		notifier     Notifier
+		notification Notification
+		err          error
+	}
+
+	var dispatches []*dispatch
+	for _, notifier := range ConfiguredNotifiers(conf) {
+		// Email filters its own recipients, other channels are a single subscriber
+		if _, isEmail := notifier.(EmailNotifier); !isEmail && !SubscribedLevel(conf, notifier.Name()).Accepts(notification.Level) {
+			continue
+		}
+
+		channelNotification, allowed := limiter.Allow(notifier.Name(), notification)
+		if !allowed {
+			continue
+		}
+
+		dispatches = append(dispatches, &dispatch{notifier: notifier, notification: channelNotification})
+	}
+
+	if simulating {
+		for _, d := range dispatches {
+			PrintSimulatedNotification(conf, d.notifier, d.notification)
+		}
+		return nil
+	}
+
+	// Notifiers are sent concurrently so a slow channel cannot hold up the others,
+	// each within NotifyTimeout and all of them within NotifyTotalTimeout
+	if conf.NotifyTotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.NotifyTotalTimeout)
+		defer cancel()
+	}
+
+	var wait sync.WaitGroup
+	for _, d := range dispatches {
+		wait.Add(1)
+		go func(d *dispatch) {
+			defer wait.Done()
+			d.err = notifyWithRetries(ctx, conf, d.notifier, d.notification)
+		}(d)
+	}
+	wait.Wait()
+
+	var failures []string
+	var failed error
+	for _, d := range dispatches {
+		if d.err != nil {
+			log.Printf("%s notification failed: %s", d.notifier.Name(), d.err)
+			failures = append(failures, fmt.Sprintf("%s: %s", d.notifier.Name(), d.err))
+			failed = fmt.Errorf("%s: %w", d.notifier.Name(), d.err)
+			WriteDeadLetter(conf, d.notifier.Name(), d.notification, d.err)
+		} else {
+			log.Printf("%s notification sent", d.notifier.Name())
+		}
+	}
+
+	// A single failure keeps the notifier error wrapped for errors.Is and errors.As
+	if len(failures) == 1 {
+		return StageError("notify", failed)
+	} else if len(failures) > 1 {
+		return StageError("notify", errors.New(strings.Join(failures, "; ")))
+	}
+
+	return nil
+}
+
+// notifyWithRetries sends through one notifier, retrying a failed notification up to
+// MaxNotifyRetries times with the RetryDelay backoff, all within NotifyTimeout
+func notifyWithRetries(ctx context.Context, conf Configuration, notifier Notifier, notification Notification) error {
+	if conf.NotifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.NotifyTimeout)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		notifyCtx, span := tracer.Start(ctx, "notify", trace.WithAttributes(attribute.String("notifier", notifier.Name())))
+		err := notifyWithContext(notifyCtx, conf, notifier, notification)
+		EndSpan(span, err)
+
+		// Wrong credentials will not fix themselves, so they are not retried
+		if err == nil || attempt >= conf.MaxNotifyRetries || ctx.Err() != nil || IsSmtpAuthError(err) {
+			return err
+		}
+
+		delay := RetryDelay(conf, attempt+1)
+		log.Printf("%s notification failed (%s), retrying in %s", notifier.Name(), err, delay)
+		select {
+		case <-clock.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifyWithContext stops waiting once ctx is done, even for notifiers such as SMTP
+// that cannot be interrupted themselves
+func notifyWithContext(ctx context.Context, conf Configuration, notifier Notifier, notification Notification) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- notifier.Notify(ctx, conf, notification)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// NotificationLimiter enforces MaxNotificationsPerHour for every channel, using the
+// saved State so the limit holds across runs. Notifications over the limit are
+// dropped and counted, and the next one that gets through reports how many.
+type NotificationLimiter struct {
+	conf  Configuration
+	store StateStore
+	state State
+	now   time.Time
+}
+
+// LoadNotificationLimiter returns a limiter, or nil when there is no limit or the
+// state cannot be read, in which case nothing is limited
+func LoadNotificationLimiter(conf Configuration) *NotificationLimiter {
+	if conf.MaxNotificationsPerHour <= 0 {
+		return nil
+	}
+
+	store := NewStateStore(conf)
+	state, err := store.Load()
+	if err != nil {
+		log.Printf("Could not load notification counts, not limiting: %s", err)
+		return nil
+	}
+
+	return &NotificationLimiter{conf: conf, store: store, state: state, now: clock.Now()}
+}
+
+// Allow reports whether channel may send now, returning the notification to send
+// with a note about any dropped since the last one
+func (l *NotificationLimiter) Allow(channel string, notification Notification) (Notification, bool) {
+	if l == nil {
+		return notification, true
+	}
+	if l.state.NotificationTimes == nil {
+		l.state.NotificationTimes = map[string][]time.Time{}
+	}
+	if l.state.DroppedNotifications == nil {
+		l.state.DroppedNotifications = map[string]int{}
+	}
+
+	var recent []time.Time
+	for _, sent := range l.state.NotificationTimes[channel] {
+		if l.now.Sub(sent) < time.Hour {
+			recent = append(recent, sent)
+		}
+	}
+
+	if len(recent) >= l.conf.MaxNotificationsPerHour {
+		l.state.NotificationTimes[channel] = recent
+		l.state.DroppedNotifications[channel]++
+		log.Printf("Dropping %s notification %q, MaxNotificationsPerHour %d reached", channel, notification.Subject, l.conf.MaxNotificationsPerHour)
+		return notification, false
+	}
+
+	l.state.NotificationTimes[channel] = append(recent, l.now)
+	if dropped := l.state.DroppedNotifications[channel]; dropped > 0 {
+		notification.Body = fmt.Sprintf("%d earlier notifications were dropped by MaxNotificationsPerHour\r\n\r\n", dropped) + notification.Body
+		delete(l.state.DroppedNotifications, channel)
+	}
+
+	return notification, true
+}
+
+// Save records the counts, reloading the state so only the notification fields change
+func (l *NotificationLimiter) Save() {
+	if l == nil {
+		return
+	}
+
+	state, err := l.store.Load()
+	if err != nil {
+		log.Printf("Could not save notification counts: %s", err)
+		return
+	}
+	state.NotificationTimes = l.state.NotificationTimes
+	state.DroppedNotifications = l.state.DroppedNotifications
+
+	if err := l.store.Save(state); err != nil {
+		log.Printf("Could not save notification counts: %s", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// pingTimeout keeps a slow monitoring endpoint from holding up the end of a run
+const pingTimeout = 10 * time.Second
+
+// PingMonitor GETs SuccessPingUrl or FailurePingUrl depending on the outcome of the
+// run, for dead man's switch monitors that alert when the pings stop. A failed ping
+// is only logged and never fails the run.
+func PingMonitor(conf Configuration, success bool) {
+	pingUrl := conf.FailurePingUrl
+	if success {
+		pingUrl = conf.SuccessPingUrl
+	}
+	if pingUrl == "" || simulating {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pingUrl, nil)
+	if err != nil {
+		log.Printf("Monitor ping failed: %s", err)
+		return
+	}
+	if conf.CorrelationHeaderName != "" && runId != "" {
+		req.Header.Set(conf.CorrelationHeaderName, runId)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Monitor ping failed: %s", err)
+		return
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Monitor ping returned status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// LinkProvider is a compiled-in LinkService, selected by its Name with LinkProvider
+type LinkProvider struct {
+	Name         string
+	Description  string
+	RequiredKeys []string
+	New          func(conf Configuration) LinkService
+}
+
+// linkProviders is the registry NewLinkService, Validate and the providers command
+// work from, so a provider added here is available everywhere
+var linkProviders = []LinkProvider{
+	{
+		Name:         "urlday",
+		Description:  "UrlDay short links",
+		RequiredKeys: []string{"UrlDayLinkId", "UrlDayApiKey"},
+		New:          func(conf Configuration) LinkService { return UrlDayService{} },
+	},
+	{
+		Name:         "s3",
+		Description:  "S3 object holding the URL as JSON or text",
+		RequiredKeys: []string{"S3Bucket", "S3Key"},
+		New:          func(conf Configuration) LinkService { return NewS3Service(conf) },
+	},
+	{
+		Name:         "webhook",
+		Description:  "generic REST endpoint, optionally HMAC signed",
+		RequiredKeys: []string{"WebhookUrl"},
+		New:          func(conf Configuration) LinkService { return WebhookService{} },
+	},
+}
+
+// NotifierProvider is a compiled-in Notifier, enabled when Enabled reports true
+type NotifierProvider struct {
+	Name         string
+	Description  string
+	RequiredKeys []string
+	Enabled      func(conf Configuration) bool
+	New          func(conf Configuration) Notifier
+}
+
+// notifierProviders is the registry ConfiguredNotifiers and the providers command
+// work from
+var notifierProviders = []NotifierProvider{
+	{
+		Name:         "email",
+		Description:  "SMTP email, always enabled",
+		RequiredKeys: []string{"SmtpHost", "SmtpPassword", "SmtpFromEmail", "SendEmailTo"},
+		Enabled:      func(conf Configuration) bool { return true },
+		New:          func(conf Configuration) Notifier { return EmailNotifier{} },
+	},
+	{
+		Name:         "slack",
+		Description:  "Slack incoming webhook",
+		RequiredKeys: []string{"SlackWebhookUrl"},
+		Enabled:      func(conf Configuration) bool { return conf.SlackWebhookUrl != "" },
+		New:          func(conf Configuration) Notifier { return SlackNotifier{} },
+	},
+}
+
+// FindLinkProvider returns the registered provider with name, or nil
+func FindLinkProvider(name string) *LinkProvider {
+	for i := range linkProviders {
+		if linkProviders[i].Name == name {
+			return &linkProviders[i]
+		}
+	}
+	return nil
+}
+
+// LinkProviderNames lists the registered provider names, for error messages
+func LinkProviderNames() string {
+	names := make([]string, 0, len(linkProviders))
+	for _, provider := range linkProviders {
+		names = append(names, provider.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ConfigString returns the value of a string key, relying on Configuration fields
+// being named after their keys
+func ConfigString(conf Configuration, key string) string {
+	field := reflect.ValueOf(conf).FieldByName(key)
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return ""
+	}
+	return field.String()
+}
+
+// RunProvidersCommand lists the link providers and notifiers with the keys they
+// need. It returns the process exit code.
+func RunProvidersCommand() int {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "Link providers (LinkProvider):")
+	for _, provider := range linkProviders {
+		fmt.Fprintf(writer, "  %s\t%s\trequires %s\n", provider.Name, provider.Description, strings.Join(provider.RequiredKeys, ", "))
+	}
+
+	fmt.Fprintln(writer, "Notifiers:")
+	for _, provider := range notifierProviders {
+		fmt.Fprintf(writer, "  %s\t%s\trequires %s\n", provider.Name, provider.Description, strings.Join(provider.RequiredKeys, ", "))
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitWait caps how long we will sleep for a reported rate-limit reset, in
+// case a provider reports a nonsensical reset time
+const maxRateLimitWait = 5 * time.Minute
+
+// RateLimiter paces requests to a provider based on the X-RateLimit-Remaining and
+// X-RateLimit-Reset headers of its previous responses.
+type RateLimiter struct {
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+var urlDayLimiter = &RateLimiter{}
+
+// Snapshot returns what the limiter last observed, for the /debug endpoint
+func (l *RateLimiter) Snapshot() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.known {
+		return map[string]interface{}{"known": false}
+	}
+	return map[string]interface{}{"known": true, "remaining": l.remaining, "reset": l.reset}
+}
+
+// Observe records the rate-limit headers of a response, if present. The reset
+// header may either be a unix timestamp or a number of seconds until the reset.
+func (l *RateLimiter) Observe(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	reset := clock.Now()
+	if value, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		if value > 1_000_000_000 {
+			reset = time.Unix(value, 0)
+		} else {
+			reset = clock.Now().Add(time.Duration(value) * time.Second)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.known = true
+	l.remaining = remaining
+	l.reset = reset
+}
+
+// Wait sleeps until the rate-limit window resets when the remaining request count
+// has dropped to threshold or below.
+func (l *RateLimiter) Wait(threshold int) {
+	l.mu.Lock()
+	if !l.known || l.remaining > threshold {
+		l.mu.Unlock()
+		return
+	}
+	wait := l.reset.Sub(clock.Now())
+	l.known = false
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return
+	}
+	if wait > maxRateLimitWait {
+		wait = maxRateLimitWait
+	}
+
+	log.Printf("Rate limit nearly exhausted, waiting %s before next request", wait.Round(time.Second))
+	clock.Sleep(wait)
+}
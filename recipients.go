@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NotifyLevel classifies a notification, and is also the level a recipient
+// subscribes to: error only gets errors, change only gets runs that updated a
+// link, and all gets every notification including no-op runs.
+type NotifyLevel string
+
+const (
+	NotifyLevelError  NotifyLevel = "error"
+	NotifyLevelChange NotifyLevel = "change"
+	NotifyLevelInfo   NotifyLevel = "info"
+	NotifyLevelAll    NotifyLevel = "all"
+)
+
+// Accepts reports whether a recipient subscribed at this level should receive a
+// notification of the given level
+func (subscribed NotifyLevel) Accepts(level NotifyLevel) bool {
+	return subscribed == NotifyLevelAll || subscribed == level
+}
+
+// ParseNotifyRecipients parses "target:level" pairs separated by commas, where the
+// target is an email address or a notifier name such as slack.
+func ParseNotifyRecipients(value string) (map[string]NotifyLevel, error) {
+	recipients := map[string]NotifyLevel{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		target, level, found := strings.Cut(item, ":")
+		if !found {
+			return nil, fmt.Errorf("recipient %q must be target:level", item)
+		}
+
+		notifyLevel := NotifyLevel(strings.ToLower(strings.TrimSpace(level)))
+		if notifyLevel != NotifyLevelError && notifyLevel != NotifyLevelChange && notifyLevel != NotifyLevelAll {
+			return nil, fmt.Errorf("recipient %q has invalid level, expected error, change or all", item)
+		}
+
+		recipients[strings.ToLower(strings.TrimSpace(target))] = notifyLevel
+	}
+
+	return recipients, nil
+}
+
+// SubscribedLevel returns the level a target is subscribed at, defaulting to all
+// for anything not listed in NotifyRecipients
+func SubscribedLevel(conf Configuration, target string) NotifyLevel {
+	if level, ok := conf.NotifyRecipients[strings.ToLower(target)]; ok {
+		return level
+	}
+	return NotifyLevelAll
+}
+
+// SplitAddresses splits a comma separated address list, dropping empty entries
+func SplitAddresses(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// EmailRecipients returns the addresses that should receive a notification of
+// level: everyone in SendEmailTo plus the addresses in NotifyRecipients, filtered by
+// the level each is subscribed at. SendEmailTo keeps its order, followed by the
+// NotifyRecipients addresses sorted, so the To header is the same on every run.
+func EmailRecipients(conf Configuration, level NotifyLevel) []string {
+	var extra []string
+	for target := range conf.NotifyRecipients {
+		if strings.Contains(target, "@") {
+			extra = append(extra, target)
+		}
+	}
+	sort.Strings(extra)
+	candidates := append(SplitAddresses(conf.SendEmailTo), extra...)
+
+	var to []string
+	seen := map[string]bool{}
+	for _, address := range candidates {
+		key := strings.ToLower(address)
+		if seen[key] || !SubscribedLevel(conf, address).Accepts(level) {
+			continue
+		}
+		seen[key] = true
+		to = append(to, address)
+	}
+
+	return to
+}
+
+// EmailEnvelope is who a notification email is delivered to
+type EmailEnvelope struct {
+	To  []string
+	Cc  []string
+	Bcc []string
+}
+
+// All returns every address the message is delivered to
+func (e EmailEnvelope) All() []string {
+	all := append([]string{}, e.To...)
+	all = append(all, e.Cc...)
+	return append(all, e.Bcc...)
+}
+
+// EmailEnvelopeFor assembles the To, CC and BCC recipients of a notification of
+// level. Lists can overlap, so every address is kept only once, compared case
+// insensitively, in the first of To, CC and BCC it appears in.
+func EmailEnvelopeFor(conf Configuration, level NotifyLevel) EmailEnvelope {
+	envelope := EmailEnvelope{To: EmailRecipients(conf, level)}
+
+	seen := map[string]bool{}
+	for _, address := range envelope.To {
+		seen[strings.ToLower(address)] = true
+	}
+
+	unique := func(addresses []string) []string {
+		var kept []string
+		for _, address := range addresses {
+			key := strings.ToLower(address)
+			if seen[key] || !SubscribedLevel(conf, address).Accepts(level) {
+				continue
+			}
+			seen[key] = true
+			kept = append(kept, address)
+		}
+		return kept
+	}
+
+	envelope.Cc = unique(SplitAddresses(conf.SendEmailCc))
+	envelope.Bcc = unique(SplitAddresses(conf.SendEmailBcc))
+
+	return envelope
+}
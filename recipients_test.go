@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmailRecipientsOrder(t *testing.T) {
+	recipients, err := ParseNotifyRecipients("zed@example.com:all, amy@example.com:change, slack:error, mia@example.com:all, ops@example.com:error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := Configuration{SendEmailTo: "ops@example.com, Boss@example.com", NotifyRecipients: recipients}
+
+	want := []string{"Boss@example.com", "amy@example.com", "mia@example.com", "zed@example.com"}
+	for i := 0; i < 20; i++ {
+		if got := EmailRecipients(conf, NotifyLevelChange); !reflect.DeepEqual(got, want) {
+			t.Fatalf("EmailRecipients = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// noRedirectClient shares the transport of httpClient but returns redirects as is,
+// so ResolveRedirect sees the first hop only
+var noRedirectClient = &http.Client{
+	Transport: httpClient.Transport,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// ResolveRedirect follows at most one redirect of url with a HEAD request and
+// returns where it points, or url itself when it does not redirect. Used with
+// CompareResolvedRedirect for links stored as a short URL of the campaign.
+func ResolveRedirect(ctx context.Context, conf Configuration, url string) (string, error) {
+	if conf.RetryAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.RetryAttemptTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	if conf.CorrelationHeaderName != "" && runId != "" {
+		req.Header.Set(conf.CorrelationHeaderName, runId)
+	}
+
+	resp, err := noRedirectClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return url, nil
+	}
+
+	// Location may be relative to the stored URL
+	location, err := resp.Location()
+	if err == http.ErrNoLocation {
+		return url, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return location.String(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteResult writes the run result to w as JSON with asJson, as Markdown with
+// format md, and not at all otherwise
+func WriteResult(w io.Writer, result *Result, format string, asJson bool) error {
+	if asJson {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	if format == "md" {
+		_, err := io.WriteString(w, ResultMarkdown(result))
+		return err
+	}
+	return nil
+}
+
+// ResultMarkdown renders the run result as a Markdown table for pasting into
+// issues, pull requests or Slack
+func ResultMarkdown(result *Result) string {
+	var md strings.Builder
+
+	fmt.Fprintf(&md, "## MailChimp To Website run %s\n\n", result.RunId)
+
+	campaign := result.LatestCampaignId
+	if campaign == "" {
+		campaign = "none"
+	} else if result.LatestWebId != 0 {
+		campaign = fmt.Sprintf("%s (web_id %d)", campaign, result.LatestWebId)
+	}
+	if result.ReportUrl != "" {
+		campaign = fmt.Sprintf("[%s](%s)", campaign, result.ReportUrl)
+	}
+	fmt.Fprintf(&md, "- Campaign: %s\n", campaign)
+	fmt.Fprintf(&md, "- Outcome: %s\n", result.Outcome())
+	fmt.Fprintf(&md, "- Duration: %s\n\n", result.Duration.Round(time.Millisecond))
+
+	md.WriteString("| Link | Old URL | New URL | Updated | Duration |\n")
+	md.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, link := range result.Links {
+		updated := "no update required"
+		if link.Updated {
+			updated = "yes"
+		} else if link.SkippedReason != "" {
+			updated = "skipped, " + link.SkippedReason
+		} else if link.UpdateRequired {
+			updated = "no"
+		} else if link.NothingToSync {
+			updated = "nothing to sync yet"
+		}
+
+		fmt.Fprintf(&md, "| %s | %s | %s | %s | %s |\n",
+			markdownCell(link.Name), markdownCell(link.CurrentUrl), markdownCell(link.LatestUrl),
+			markdownCell(updated), link.Duration.Round(time.Millisecond))
+	}
+
+	return md.String()
+}
+
+// markdownCell keeps a value from breaking out of its table cell
+func markdownCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(value)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+
+// ConfigureHttpTransport applies the connection reuse settings to the shared client
+func ConfigureHttpTransport(conf Configuration) {
+	transport := httpClient.Transport.(*http.Transport)
+	transport.MaxIdleConns = conf.MaxIdleConns
+	transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = conf.IdleConnTimeout
+}
+
+// runId identifies the current run in logs, notifications and, through
+// CorrelationHeaderName, every outbound request. BeginRun sets it.
+var runId string
+
+// BeginRun starts a new run with a fresh runId, returning it
+func BeginRun() string {
+	runId = NewUuid()
+	log.SetPrefix("[" + runId + "] ")
+	return runId
+}
+
+// NewUuid returns a random version 4 UUID
+func NewUuid() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// SendRequest performs req with the shared HTTP client. Transport errors and
+// 429/5xx responses are retried up to conf.RetryCount times, and every retry is
+// counted against stage in the run Result, which may be nil outside of a run.
+// A final response with a status outside ok is returned as an error.
+//
+// Each attempt is limited to RetryAttemptTimeout, retries back off exponentially
+// from RetryDelaySeconds up to RetryMaxDelay, and no retry is started that would
+// take the request past RetryMaxElapsed, so the worst case is always bounded.
+func SendRequest(conf Configuration, result *Result, stage string, ok StatusSet, req *http.Request) (*http.Response, error) {
+	result.CountRetries(stage, 0)
+
+	if conf.CorrelationHeaderName != "" && runId != "" {
+		req.Header.Set(conf.CorrelationHeaderName, runId)
+	}
+
+	started := clock.Now()
+
+	retryCount := conf.RetryCount
+	if !conf.FeatureEnabled("retry") {
+		retryCount = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			result.CountRetries(stage, 1)
+
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
+
+		resp, err := sendAttempt(conf, req)
+		if err == nil && ok.Contains(resp.StatusCode) {
+			return resp, nil
+		}
+
+		delay := RetryDelay(conf, attempt+1)
+		outOfTime := conf.RetryMaxElapsed > 0 && clock.Now().Sub(started)+delay > conf.RetryMaxElapsed
+
+		if attempt >= retryCount || outOfTime || req.Context().Err() != nil || (err == nil && !IsRetryableStatus(resp.StatusCode)) {
+			if outOfTime && attempt < retryCount {
+				log.Printf("%s request not retried, RetryMaxElapsed %s would be exceeded", stage, conf.RetryMaxElapsed)
+			}
+			if err != nil {
+				return nil, err
+			}
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, snippetLength+1))
+			_ = resp.Body.Close()
+			return nil, &UnexpectedStatusError{Stage: stage, StatusCode: resp.StatusCode, Body: body}
+		}
+
+		if err == nil {
+			_ = resp.Body.Close()
+			err = fmt.Errorf("response status %d", resp.StatusCode)
+		}
+		log.Printf("%s request failed (%s), retrying in %s", stage, err, delay)
+
+		select {
+		case <-clock.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// UnexpectedStatusError is returned by SendRequest for a final response outside the
+// accepted statuses, so callers can react to specific ones
+type UnexpectedStatusError struct {
+	Stage      string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("%s request returned unexpected status %d: %s", e.Stage, e.StatusCode, BodySnippet(e.Body))
+}
+
+// IsStatusError reports whether err is an UnexpectedStatusError with the given status
+func IsStatusError(err error, statusCode int) bool {
+	var statusErr *UnexpectedStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+// sendAttempt performs a single attempt, limited to RetryAttemptTimeout. The
+// timeout also covers reading the body, so it is only released when it is closed.
+func sendAttempt(conf Configuration, req *http.Request) (*http.Response, error) {
+	if conf.RetryAttemptTimeout <= 0 {
+		return httpClient.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), conf.RetryAttemptTimeout)
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// RetryDelay is the backoff before the given retry: RetryDelaySeconds doubled for
+// every earlier retry, capped at RetryMaxDelay
+func RetryDelay(conf Configuration, retry int) time.Duration {
+	delay := time.Duration(conf.RetryDelaySeconds) * time.Second
+	for i := 1; i < retry && (conf.RetryMaxDelay <= 0 || delay < conf.RetryMaxDelay); i++ {
+		delay *= 2
+	}
+	if conf.RetryMaxDelay > 0 && delay > conf.RetryMaxDelay {
+		delay = conf.RetryMaxDelay
+	}
+	return delay
+}
+
+// snippetLength is how much of an unexpected response body is included in errors
+const snippetLength = 200
+
+// BodySnippet shortens a response body for inclusion in an error message
+func BodySnippet(body []byte) string {
+	snippet := strings.TrimSpace(string(body))
+	if len(snippet) > snippetLength {
+		snippet = snippet[:snippetLength] + "..."
+	}
+	return fmt.Sprintf("%q", snippet)
+}
+
+func IsRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
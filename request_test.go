@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelayBacksOff(t *testing.T) {
+	conf := Configuration{RetryDelaySeconds: 1, RetryMaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range want {
+		if got := RetryDelay(conf, i+1); got != delay {
+			t.Errorf("RetryDelay(%d) = %s, want %s", i+1, got, delay)
+		}
+	}
+}
+
+func TestSendRequestWaitsOutTheBackoff(t *testing.T) {
+	fake := NewFakeClock(time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC))
+	defer func(previous Clock) { clock = previous }(clock)
+	clock = fake
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	conf := Configuration{RetryCount: 2, RetryDelaySeconds: 1, RetryMaxDelay: 30 * time.Second}
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := NewResult()
+	done := make(chan error, 1)
+	go func() {
+		resp, err := SendRequest(conf, result, "test", DefaultOkStatuses, req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		done <- err
+	}()
+
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		fake.BlockUntil(1)
+		fake.Advance(delay - time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("SendRequest returned before its %s backoff passed: %v", delay, err)
+		default:
+		}
+		fake.Advance(time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("SendRequest: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendRequest did not finish")
+	}
+
+	if atomic.LoadInt32(&requests) != 3 || result.Retries["test"] != 2 {
+		t.Errorf("got %d requests and %d retries, want 3 and 2", requests, result.Retries["test"])
+	}
+}
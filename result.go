@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result collects everything that happened during a single run so it can be
+// rendered into the summary email.
+type Result struct {
+	RunId              string
+	Started            time.Time
+	Duration           time.Duration // of the sync itself, excluding notifications
+	LatestCampaignId   string
+	LatestWebId        int
+	ReportUrl          string
+	NoCampaignReason   string // why no campaign was selected, when one was fetched but skipped
+	LatestContentHash  string
+	LatestCampaignJson []byte `json:"-"`
+	SmallCampaigns     []string
+	SmallCampaignId    string // the newest of SmallCampaigns, notified once per campaign
+	Baseline           bool
+	Recovered          bool
+	ReadsDisagreed     bool
+	Links              []*LinkResult
+	Retries            map[string]int
+	ShortUrls          map[string]string
+
+	// retriesMu guards Retries, as notifiers are sent concurrently
+	retriesMu sync.Mutex
+}
+
+// LinkResult is the outcome for a single UrlDay link
+type LinkResult struct {
+	Name                      string
+	LinkId                    string
+	CurrentUrl                string
+	LatestUrl                 string
+	UpdateRequired            bool
+	Updated                   bool
+	Verified                  bool
+	VerificationSkipped       bool
+	VerificationSkippedReason string // VerifyUpdate=false or Features=-verify
+	SkippedReason             string
+	PreviousShortUrl          string // set when TrackShortUrl saw the short URL change
+	DriftedFrom               string // the URL the state recorded, when the link was edited elsewhere
+	UntransformedUrl          string // the campaign URL before the link transform template, if any
+	ArchiveUnverified         bool   // the VerifyArchiveReachable check timed out and was skipped
+	RecreatedFrom             string // the configured id, when RecreateMissingLink created LinkId
+	NothingToSync             bool   // no campaign URL and no link URL yet, e.g. a fresh account
+	ShortUrl                  string
+	Cached                    bool      // CurrentUrl came from the LinkCacheTtl cache
+	ReadAt                    time.Time // when CurrentUrl was read, unless Cached
+	Duration                  time.Duration
+
+	// pending is set by SyncLink for an update ApplyLinkUpdates still has to push
+	pending bool
+}
+
+func NewResult() *Result {
+	return &Result{Retries: map[string]int{}, ShortUrls: map[string]string{}}
+}
+
+// Primary returns the result for the main UrlDayLinkId link
+func (r *Result) Primary() *LinkResult {
+	if len(r.Links) == 0 {
+		return &LinkResult{}
+	}
+	return r.Links[0]
+}
+
+// ShortUrlChanged reports whether any tracked short URL changed since the last run
+func (r *Result) ShortUrlChanged() bool {
+	for _, link := range r.Links {
+		if link.PreviousShortUrl != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyUpdated reports whether any link was changed during the run
+func (r *Result) AnyUpdated() bool {
+	for _, link := range r.Links {
+		if link.Updated {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome classifies a completed run: partial when some links were updated but
+// others that needed it were not, updated or no-op otherwise
+func (r *Result) Outcome() string {
+	pending := false
+	for _, link := range r.Links {
+		if link.UpdateRequired && !link.Updated {
+			pending = true
+		}
+	}
+
+	if r.AnyUpdated() && pending {
+		return OutcomePartial
+	} else if r.AnyUpdated() {
+		return OutcomeUpdated
+	}
+	return OutcomeNoop
+}
+
+func (r *Result) Summary(messages Messages) string {
+	summary := ""
+	for _, link := range r.Links {
+		if len(r.Links) > 1 {
+			summary = summary + messages.Format("link_header", link.Name, link.LinkId) + "\r\n"
+		}
+		summary = summary + link.Summary(messages) + "\r\n\r\n"
+	}
+
+	if r.ReportUrl != "" {
+		summary = summary + messages.Format("report_link", r.ReportUrl) + "\r\n\r\n"
+	}
+	if len(r.SmallCampaigns) > 0 {
+		summary = summary + messages.Format("below_min_emails_sent", strings.Join(r.SmallCampaigns, ", ")) + "\r\n\r\n"
+	}
+	if r.Recovered {
+		summary = summary + messages.Format("recovered") + "\r\n\r\n"
+	}
+	if r.Baseline {
+		summary = summary + messages.Format("baseline") + "\r\n\r\n"
+	}
+
+	return summary + messages.Format("run_id", r.RunId) + "\r\n\r\n" + r.RetryMetrics()
+}
+
+func (l *LinkResult) Summary(messages Messages) string {
+	summary := messages.Format("current_link", l.CurrentUrl) + "\r\n" + messages.Format("current_mailchimp", l.LatestUrl) + "\r\n"
+	if l.RecreatedFrom != "" {
+		summary = summary + messages.Format("link_recreated", l.RecreatedFrom, l.LinkId) + "\r\n"
+	}
+	if l.UntransformedUrl != "" {
+		summary = summary + messages.Format("url_transformed", l.UntransformedUrl) + "\r\n"
+	}
+	if l.DriftedFrom != "" {
+		summary = summary + messages.Format("drift_detected", l.DriftedFrom) + "\r\n"
+	}
+	if l.PreviousShortUrl != "" {
+		summary = summary + messages.Format("short_url_changed", l.PreviousShortUrl, l.ShortUrl) + "\r\n"
+	}
+
+	if l.UpdateRequired {
+		summary = summary + "\t" + messages.Format("update_required")
+		if l.SkippedReason != "" {
+			summary = summary + "\r\n\t" + messages.Format("update_skipped", l.SkippedReason)
+		}
+		if l.Updated {
+			summary = summary + "\r\n\t" + messages.Format("update_successful")
+		}
+		if l.ArchiveUnverified {
+			summary = summary + "\r\n\t" + messages.Format("archive_unverified")
+		}
+		if l.Verified {
+			summary = summary + "\r\n\t" + messages.Format("verification_successful")
+		} else if l.VerificationSkipped {
+			summary = summary + "\r\n\t" + messages.Format("verification_skipped", l.VerificationSkippedReason)
+		}
+	} else if l.NothingToSync {
+		summary = summary + "\t" + messages.Format("nothing_to_sync")
+	} else {
+		summary = summary + "\t" + messages.Format("no_update_required")
+	}
+
+	return summary
+}
+
+// RecordShortUrl keeps the short URL a provider reported for linkId
+func (r *Result) RecordShortUrl(linkId string, shortUrl string) {
+	if r == nil || shortUrl == "" {
+		return
+	}
+	r.ShortUrls[linkId] = shortUrl
+}
+
+func (r *Result) CountRetries(stage string, retries int) {
+	if r == nil {
+		return
+	}
+	r.retriesMu.Lock()
+	defer r.retriesMu.Unlock()
+	r.Retries[stage] += retries
+}
+
+// RetryMetrics renders the per-stage retry counts as metric lines, e.g. retries{stage="mailchimp"} 0
+func (r *Result) RetryMetrics() string {
+	stages := make([]string, 0, len(r.Retries))
+	for stage := range r.Retries {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	lines := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		lines = append(lines, fmt.Sprintf("retries{stage=%q} %d", stage, r.Retries[stage]))
+	}
+
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ResultWebhookPayload is posted to ResultWebhookUrl after every run. Result is
+// missing when the run failed before producing one.
+type ResultWebhookPayload struct {
+	RunId    string  `json:"run_id"`
+	Instance string  `json:"instance"`
+	Success  bool    `json:"success"`
+	Outcome  string  `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+	Result   *Result `json:"result,omitempty"`
+}
+
+// PostResultWebhook posts the outcome of the run as JSON to ResultWebhookUrl for
+// custom automation, retried like any other request and signed like link updates
+// with ResultWebhookSigningSecret. A failed post is only logged and never fails
+// the run.
+func PostResultWebhook(conf Configuration, result *Result, runErr error) {
+	if conf.ResultWebhookUrl == "" || simulating {
+		return
+	}
+
+	payload := ResultWebhookPayload{RunId: runId, Instance: conf.InstanceName, Success: runErr == nil, Outcome: OutcomeError, Result: result}
+	if runErr != nil {
+		payload.Error = runErr.Error()
+	} else if result != nil {
+		payload.Outcome = result.Outcome()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Result webhook failed: %s", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), "POST", conf.ResultWebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Result webhook failed: %s", err)
+		return
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if conf.ResultWebhookSigningSecret != "" {
+		req.Header.Set(conf.WebhookSignatureHeader, WebhookSignature(conf.ResultWebhookSigningSecret, body))
+	}
+
+	resp, err := SendRequest(conf, nil, "result-webhook", DefaultOkStatuses, req)
+	if err != nil {
+		log.Printf("Result webhook failed: %s", err)
+		return
+	}
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"io"
+	"strings"
+)
+
+// S3Service mirrors the latest URL into an S3 object, for static sites that read
+// it from there. The link id is the object key. With S3Format=json the object is
+// {"url": "..."}, with S3Format=text it is just the URL.
+type S3Service struct {
+	client *s3.Client
+	err    error
+}
+
+// NewS3Service creates the client using the standard AWS credential chain
+// (environment, shared config, instance or task role).
+func NewS3Service(conf Configuration) *S3Service {
+	options := []func(*awsconfig.LoadOptions) error{}
+	if conf.S3Region != "" {
+		options = append(options, awsconfig.WithRegion(conf.S3Region))
+	}
+
+	awsConf, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return &S3Service{err: fmt.Errorf("loading AWS configuration: %w", err)}
+	}
+
+	return &S3Service{client: s3.NewFromConfig(awsConf)}
+}
+
+type s3LinkObject struct {
+	Url string `json:"url"`
+}
+
+func (s *S3Service) Name() string {
+	return "s3"
+}
+
+func (s *S3Service) GetURL(ctx context.Context, conf Configuration, result *Result, linkId string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(conf.S3Bucket),
+		Key:    aws.String(linkId),
+	})
+
+	// A missing object just means nothing has been mirrored yet
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading s3://%s/%s: %w", conf.S3Bucket, linkId, err)
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if conf.S3Format == "text" {
+		return strings.TrimSpace(string(body)), nil
+	}
+
+	object := s3LinkObject{}
+	if err := json.Unmarshal(body, &object); err != nil {
+		return "", fmt.Errorf("decoding s3://%s/%s: %w", conf.S3Bucket, linkId, err)
+	}
+
+	return object.Url, nil
+}
+
+func (s *S3Service) UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	body := []byte(url + "\n")
+	contentType := "text/plain; charset=utf-8"
+	if conf.S3Format != "text" {
+		var err error
+		if body, err = json.Marshal(s3LinkObject{Url: url}); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(conf.S3Bucket),
+		Key:         aws.String(linkId),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("writing s3://%s/%s: %w", conf.S3Bucket, linkId, err)
+	}
+
+	return nil
+}
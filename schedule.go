@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UpdateSchedule restricts updates to a set of weekday/time windows, e.g.
+// "Mon-Fri 06:00-12:00" or "Tue,Thu 08:00-10:30; Sat". Windows are separated by
+// ";", the time range is optional (meaning all day) and its end is exclusive.
+// Times are evaluated in the local timezone of the host.
+type UpdateSchedule struct {
+	raw     string
+	windows []scheduleWindow
+}
+
+type scheduleWindow struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int // minutes since midnight, exclusive
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func ParseUpdateSchedule(value string) (*UpdateSchedule, error) {
+	schedule := &UpdateSchedule{raw: strings.TrimSpace(value)}
+
+	for _, part := range strings.Split(value, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid schedule window %q", strings.TrimSpace(part))
+		}
+
+		window := scheduleWindow{start: 0, end: 24 * 60}
+		if err := parseScheduleDays(fields[0], &window.days); err != nil {
+			return nil, err
+		}
+		if len(fields) == 2 {
+			start, end, found := strings.Cut(fields[1], "-")
+			if !found {
+				return nil, fmt.Errorf("invalid schedule time range %q", fields[1])
+			}
+			var err error
+			if window.start, err = parseScheduleTime(start); err != nil {
+				return nil, err
+			}
+			if window.end, err = parseScheduleTime(end); err != nil {
+				return nil, err
+			}
+			if window.end <= window.start {
+				return nil, fmt.Errorf("schedule time range %q must end after it starts", fields[1])
+			}
+		}
+
+		schedule.windows = append(schedule.windows, window)
+	}
+
+	if len(schedule.windows) == 0 {
+		return nil, fmt.Errorf("schedule %q contains no windows", value)
+	}
+
+	return schedule, nil
+}
+
+func parseScheduleDays(value string, days *[7]bool) error {
+	if value == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := weekdayNames[strings.ToLower(first)]
+		if !ok {
+			return fmt.Errorf("invalid schedule day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[strings.ToLower(last)]; !ok {
+				return fmt.Errorf("invalid schedule day %q", last)
+			}
+		}
+
+		// Ranges may wrap around the week, e.g. Fri-Mon
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseScheduleTime(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time %q, expected HH:MM", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Allows reports whether t falls inside any of the schedule's windows. A nil
+// schedule allows every time.
+func (s *UpdateSchedule) Allows(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range s.windows {
+		if window.days[t.Weekday()] && minute >= window.start && minute < window.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *UpdateSchedule) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.raw
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// maxScreenshotSize bounds what is read from the screenshot service per page
+const maxScreenshotSize = 10 << 20
+
+// CaptureScreenshots returns before and after screenshots of every updated link,
+// taken by ScreenshotServiceUrl, for the success email. A failed screenshot is
+// only logged, it never fails the run.
+func CaptureScreenshots(ctx context.Context, conf Configuration, result *Result) []EmailAttachment {
+	var attachments []EmailAttachment
+	for _, link := range result.Links {
+		if !link.Updated {
+			continue
+		}
+
+		pages := []struct{ name, url string }{{"before", link.CurrentUrl}, {"after", link.LatestUrl}}
+		for _, page := range pages {
+			if page.url == "" {
+				continue
+			}
+
+			attachment, err := CaptureScreenshot(ctx, conf, result, page.url)
+			if err != nil {
+				log.Printf("Could not capture %s screenshot of %s link page %s: %s", page.name, link.Name, page.url, err)
+				continue
+			}
+
+			attachment.Filename = link.Name + "-" + page.name + attachment.Filename
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	return attachments
+}
+
+// CaptureScreenshot GETs ScreenshotServiceUrl with {{url}} replaced by the query
+// escaped page URL and returns the image it responds with. The Filename is only
+// the extension for the returned content type.
+func CaptureScreenshot(ctx context.Context, conf Configuration, result *Result, pageUrl string) (EmailAttachment, error) {
+	if conf.ScreenshotTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.ScreenshotTimeout)
+		defer cancel()
+	}
+
+	serviceUrl := strings.ReplaceAll(conf.ScreenshotServiceUrl, "{{url}}", neturl.QueryEscape(pageUrl))
+	req, err := http.NewRequestWithContext(ctx, "GET", serviceUrl, nil)
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+
+	resp, err := SendRequest(conf, result, "screenshot", DefaultOkStatuses, req)
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxScreenshotSize+1))
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+	if len(data) > maxScreenshotSize {
+		return EmailAttachment{}, fmt.Errorf("screenshot is larger than %d bytes", maxScreenshotSize)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !strings.HasPrefix(mediaType, "image/") {
+		return EmailAttachment{}, fmt.Errorf("screenshot service returned %s, not an image", contentType)
+	}
+
+	extension := ".png"
+	if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 && mediaType != "image/png" {
+		extension = extensions[0]
+	}
+
+	return EmailAttachment{Filename: extension, ContentType: mediaType, Data: data}, nil
+}
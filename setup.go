@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"golang.org/x/term"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setupField is one value asked for by the setup wizard
+type setupField struct {
+	Key      string
+	Prompt   string
+	Default  string
+	Secret   bool
+	Optional bool
+	Validate func(value string) error
+}
+
+var setupFields = []setupField{
+	{Key: "SmtpHost", Prompt: "SMTP server host", Default: "smtp.gmail.com"},
+	{Key: "SmtpPort", Prompt: "SMTP server port", Default: "587", Validate: validatePort},
+	{Key: "SmtpUsername", Prompt: "SMTP username", Optional: true},
+	{Key: "SmtpPassword", Prompt: "SMTP password (for Gmail an app password)", Secret: true},
+	{Key: "SmtpFromEmail", Prompt: "Send notification emails from", Validate: validateAddresses},
+	{Key: "SendEmailTo", Prompt: "Send notification emails to (comma separated)", Validate: validateAddresses},
+	{Key: "MailChimpApiKey", Prompt: "MailChimp API key", Secret: true},
+	{Key: "MailChimpServerPrefix", Prompt: "MailChimp server prefix (the part after - in the API key, e.g. us21)"},
+	{Key: "UrlDayApiKey", Prompt: "UrlDay API key", Secret: true},
+	{Key: "UrlDayLinkId", Prompt: "UrlDay link id to keep updated"},
+}
+
+// RunSetupCommand interactively asks for the main configuration values and writes
+// them to .env, keeping any other keys already in the file. It returns the process
+// exit code.
+func RunSetupCommand() int {
+	const envFile = ".env"
+	input := bufio.NewReader(os.Stdin)
+
+	values := map[string]string{}
+	if _, err := os.Stat(envFile); err == nil {
+		existing, err := godotenv.Read(envFile)
+		if err != nil {
+			fmt.Printf("Could not read existing %s: %s\n", envFile, err)
+			return 1
+		}
+		values = existing
+		fmt.Printf("Existing values from %s are offered as defaults, press enter to keep them.\n", envFile)
+	}
+
+	for _, field := range setupFields {
+		if field.Key == "MailChimpServerPrefix" && values[field.Key] == "" {
+			// MailChimp keys end in -<prefix>, so offer that as the default
+			if _, prefix, found := strings.Cut(values["MailChimpApiKey"], "-"); found {
+				field.Default = prefix
+			}
+		}
+
+		value, err := promptField(input, field, values[field.Key])
+		if err != nil {
+			fmt.Printf("\nSetup aborted: %s\n", err)
+			return 1
+		}
+		values[field.Key] = value
+
+		if field.Key == "MailChimpServerPrefix" && promptYesNo(input, "Test the MailChimp connection now?") {
+			if err := PingMailChimp(context.Background(), setupConfiguration(values)); err != nil {
+				fmt.Printf("\tMailChimp connection failed: %s\n", err)
+			} else {
+				fmt.Println("\tMailChimp connection OK")
+			}
+		}
+		if field.Key == "UrlDayLinkId" && promptYesNo(input, "Test the UrlDay connection now?") {
+			conf := setupConfiguration(values)
+			if currentUrl, err := GetCurrentUrlDay(context.Background(), conf, nil, conf.UrlDayLinkId); err != nil {
+				fmt.Printf("\tUrlDay connection failed: %s\n", err)
+			} else {
+				fmt.Printf("\tUrlDay connection OK, link currently points to %s\n", currentUrl)
+			}
+		}
+	}
+
+	if _, err := os.Stat(envFile); err == nil {
+		if !promptYesNo(input, fmt.Sprintf("Overwrite %s? Other keys are kept but comments are lost.", envFile)) {
+			fmt.Println("Nothing written")
+			return 1
+		}
+	}
+
+	content, err := godotenv.Marshal(values)
+	if err != nil {
+		fmt.Printf("Could not write %s: %s\n", envFile, err)
+		return 1
+	}
+	// The file holds API keys and passwords, so keep it private to the user
+	if err := os.WriteFile(envFile, []byte(content+"\n"), 0600); err != nil {
+		fmt.Printf("Could not write %s: %s\n", envFile, err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %s, run the validate command to check the full configuration\n", envFile)
+	return 0
+}
+
+// promptField asks for one value until it is valid. Secrets are read without echo
+// when stdin is a terminal, and their current value is never shown.
+func promptField(input *bufio.Reader, field setupField, current string) (string, error) {
+	defaultValue := field.Default
+	if current != "" {
+		defaultValue = current
+	}
+
+	for {
+		prompt := field.Prompt
+		switch {
+		case field.Secret && defaultValue != "":
+			prompt = prompt + " [keep current]"
+		case defaultValue != "":
+			prompt = prompt + " [" + defaultValue + "]"
+		case field.Optional:
+			prompt = prompt + " (optional)"
+		}
+		fmt.Print(prompt + ": ")
+
+		var value string
+		var err error
+		if field.Secret && term.IsTerminal(int(os.Stdin.Fd())) {
+			var secret []byte
+			secret, err = term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			value = string(secret)
+		} else {
+			value, err = input.ReadString('\n')
+		}
+		if err != nil && value == "" {
+			return "", err
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			value = defaultValue
+		}
+
+		if value == "" && !field.Optional {
+			fmt.Println("\tA value is required")
+			continue
+		}
+		if value != "" && field.Validate != nil {
+			if err := field.Validate(value); err != nil {
+				fmt.Printf("\t%s\n", err)
+				continue
+			}
+		}
+
+		return value, nil
+	}
+}
+
+func promptYesNo(input *bufio.Reader, question string) bool {
+	fmt.Print(question + " [y/N]: ")
+	answer, _ := input.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// setupConfiguration builds enough of a Configuration from the wizard values to
+// test connectivity, using the same defaults as ReadConfiguration
+func setupConfiguration(values map[string]string) Configuration {
+	return Configuration{
+		MailChimpServerPrefix: values["MailChimpServerPrefix"],
+		MailChimpApiKey:       values["MailChimpApiKey"],
+		MailChimpApiVersion:   "3.0",
+		MailChimpOkStatuses:   DefaultOkStatuses,
+		RetryAttemptTimeout:   30 * time.Second,
+		UrlDayLinkId:          values["UrlDayLinkId"],
+		UrlDayApiKey:          values["UrlDayApiKey"],
+		UrlDayOkStatuses:      DefaultOkStatuses,
+		UrlDayRequireJson:     true,
+	}
+}
+
+func validatePort(value string) error {
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		return errors.New("port must be a number between 1 and 65535")
+	}
+	return nil
+}
+
+func validateAddresses(value string) error {
+	for _, address := range SplitAddresses(value) {
+		if !strings.Contains(address, "@") {
+			return fmt.Errorf("%q is not an email address", address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// simulating is set by -simulate: everything is read for real, but links are not
+// updated, state is not saved, monitors are not pinged and notifications are
+// printed to stderr instead of being sent, so stdout only carries the -json or
+// -format result
+var simulating bool
+
+// SimulatedStateStore reads the real state but never saves it
+type SimulatedStateStore struct {
+	StateStore
+}
+
+func (s SimulatedStateStore) Save(state State) error {
+	Debugf("Simulating, not saving state")
+	return nil
+}
+
+// PrintSimulatedNotification renders what notifier would have sent, including
+// who would have received it
+func PrintSimulatedNotification(conf Configuration, notifier Notifier, notification Notification) {
+	fmt.Fprintf(os.Stderr, "--- %s notification (level %s, outcome %s)\n", notifier.Name(), notification.Level, notification.Outcome)
+
+	switch notifier.(type) {
+	case EmailNotifier:
+		envelope := EmailEnvelopeFor(conf, notification.Level)
+		if len(envelope.All()) == 0 {
+			fmt.Fprintln(os.Stderr, "No recipients at this level, nothing would be sent")
+		}
+		fmt.Fprintf(os.Stderr, "To: %s\nCc: %s\nBcc: %s\n", strings.Join(envelope.To, ", "), strings.Join(envelope.Cc, ", "), strings.Join(envelope.Bcc, ", "))
+	case SlackNotifier:
+		if conf.SlackBlockKit {
+			blocks, err := RenderSlackBlocks(conf, notification)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Block Kit rendering failed, text would be sent: %s\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Blocks: %s\n", blocks)
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Subject: %s\n\n%s\n", notification.Subject, notification.Body)
+	for _, attachment := range notification.Attachments {
+		fmt.Fprintf(os.Stderr, "Attachment: %s (%s, %d bytes)\n", attachment.Filename, attachment.ContentType, len(attachment.Data))
+	}
+	fmt.Fprintln(os.Stderr)
+}
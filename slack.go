@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SlackNotifier posts notifications to a Slack incoming webhook, either as plain
+// text or, with SlackBlockKit=true, as a Block Kit layout.
+type SlackNotifier struct{}
+
+// defaultSlackBlockTemplate is used when SlackBlockKit is enabled without a
+// SlackBlockTemplate file. Placeholders are replaced by SlackTemplateValues.
+const defaultSlackBlockTemplate = `[
+	{"type": "header", "text": {"type": "plain_text", "text": "{{subject}}"}},
+	{"type": "section", "fields": [
+		{"type": "mrkdwn", "text": "*Old URL*\n{{old_url}}"},
+		{"type": "mrkdwn", "text": "*New URL*\n{{new_url}}"}
+	]},
+	{"type": "section", "text": {"type": "mrkdwn", "text": "` + "```{{body}}```" + `"}},
+	{"type": "context", "elements": [
+		{"type": "mrkdwn", "text": "{{status}} at {{timestamp}} on {{instance}}"}
+	]}
+]`
+
+func (SlackNotifier) Name() string {
+	return "slack"
+}
+
+func (SlackNotifier) Notify(ctx context.Context, conf Configuration, notification Notification) error {
+	payload := map[string]interface{}{"text": notification.Subject + "\n" + notification.Body}
+
+	if conf.SlackBlockKit {
+		blocks, err := RenderSlackBlocks(conf, notification)
+		if err != nil {
+			log.Printf("Slack Block Kit rendering failed, falling back to text: %s", err)
+		} else {
+			payload["blocks"] = blocks
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", conf.SlackWebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := SendRequest(conf, notification.Result, "notify", DefaultOkStatuses, req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// RenderSlackBlocks fills the Block Kit template (SlackBlockTemplate or the default
+// layout) and checks the result is a valid JSON array of blocks.
+func RenderSlackBlocks(conf Configuration, notification Notification) (json.RawMessage, error) {
+	template := defaultSlackBlockTemplate
+	if conf.SlackBlockTemplate != "" {
+		data, err := os.ReadFile(conf.SlackBlockTemplate)
+		if err != nil {
+			return nil, err
+		}
+		template = string(data)
+	}
+
+	replacements := []string{}
+	for key, value := range SlackTemplateValues(conf, notification) {
+		// Values are substituted inside JSON strings, so escape them as JSON
+		escaped, _ := json.Marshal(value)
+		replacements = append(replacements, "{{"+key+"}}", string(escaped[1:len(escaped)-1]))
+	}
+	rendered := strings.NewReplacer(replacements...).Replace(template)
+
+	var blocks []json.RawMessage
+	if err := json.Unmarshal([]byte(rendered), &blocks); err != nil {
+		return nil, fmt.Errorf("template is not a valid JSON array of blocks: %w", err)
+	}
+
+	return json.RawMessage(rendered), nil
+}
+
+// SlackTemplateValues are the placeholders available to SlackBlockTemplate, used as {{name}}
+func SlackTemplateValues(conf Configuration, notification Notification) map[string]string {
+	values := map[string]string{
+		"subject":    notification.Subject,
+		"body":       notification.Body,
+		"timestamp":  notification.Time.Format(time.RFC1123),
+		"instance":   conf.InstanceName,
+		"status":     "Success",
+		"old_url":    "-",
+		"new_url":    "-",
+		"report_url": "-",
+	}
+
+	if !notification.Success {
+		values["status"] = "Error"
+	}
+	if notification.Result != nil {
+		primary := notification.Result.Primary()
+		values["old_url"] = primary.CurrentUrl
+		values["new_url"] = primary.LatestUrl
+		if notification.Result.ReportUrl != "" {
+			values["report_url"] = notification.Result.ReportUrl
+		}
+	}
+
+	return values
+}
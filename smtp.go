@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"syscall"
+)
+
+// SmtpAuthError means the server rejected the credentials, which no retry fixes
+type SmtpAuthError struct {
+	Err error
+}
+
+func (e *SmtpAuthError) Error() string {
+	return "SMTP authentication failed, check SmtpFromEmail and SmtpPassword: " + e.Err.Error()
+}
+
+func (e *SmtpAuthError) Unwrap() error {
+	return e.Err
+}
+
+// IsSmtpAuthError reports whether err is, or wraps, an SmtpAuthError
+func IsSmtpAuthError(err error) bool {
+	var authErr *SmtpAuthError
+	return errors.As(err, &authErr)
+}
+
+// SendSmtp delivers an already rendered message. Transient failures, such as relays
+// dropping long idle connections or 4xx replies, are retried up to SmtpRetryCount
+// times on a new connection, SmtpRetryDelay apart and doubling. Authentication
+// failures and other permanent errors are returned straight away.
+func SendSmtp(conf Configuration, from string, to []string, message []byte) error {
+	delay := conf.SmtpRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := sendSmtpOnce(conf, from, to, message)
+		if err == nil || attempt >= conf.SmtpRetryCount || !IsTransientSmtpError(err) {
+			return err
+		}
+
+		log.Printf("SMTP send failed (%s), reconnecting to retry in %s", err, delay)
+		clock.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// IsTransientSmtpError reports whether a new attempt could succeed: the connection
+// was dropped or timed out, or the server answered with a 4xx temporary failure
+func IsTransientSmtpError(err error) bool {
+	if IsSmtpAuthError(err) {
+		return false
+	}
+
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code >= 400 && protocolErr.Code < 500
+	}
+
+	var netErr net.Error
+	return IsConnectionReset(err) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// sendSmtpOnce is smtp.SendMail split into its steps, so every step is under our
+// control: TLS from the start with SmtpSecurity=tls or STARTTLS when offered with
+// starttls, then PLAIN auth when offered.
+func sendSmtpOnce(conf Configuration, from string, to []string, message []byte) error {
+	client, err := dialSmtp(conf)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Without SmtpLocalName net/smtp announces itself as localhost, which strict
+	// servers reject when they require a fully qualified name
+	if conf.SmtpLocalName != "" {
+		if err := client.Hello(conf.SmtpLocalName); err != nil {
+			return err
+		}
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok && conf.SmtpSecurity == "starttls" {
+		if err := client.StartTLS(&tls.Config{ServerName: conf.SmtpHost}); err != nil {
+			return err
+		}
+	}
+
+	if ok, _ := client.Extension("AUTH"); ok {
+		auth := smtp.PlainAuth("", conf.SmtpFromEmail, conf.SmtpPassword, conf.SmtpHost)
+		if err := client.Auth(auth); err != nil {
+			// Only 535 (bad credentials) and 534 (mechanism refused for this account)
+			// are about the credentials. Dropped connections, 4xx replies and
+			// PlainAuth refusing an unencrypted connection are reported as they are.
+			var protocolErr *textproto.Error
+			if errors.As(err, &protocolErr) && (protocolErr.Code == 535 || protocolErr.Code == 534) {
+				return &SmtpAuthError{Err: err}
+			}
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// IsConnectionReset reports whether err means the server closed the connection
+func IsConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// dialSmtp connects to SmtpHost, over TLS for SmtpSecurity=tls
+func dialSmtp(conf Configuration) (*smtp.Client, error) {
+	addr := net.JoinHostPort(conf.SmtpHost, conf.SmtpPort)
+	if conf.SmtpSecurity != "tls" {
+		return smtp.Dial(addr)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: conf.SmtpHost})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, conf.SmtpHost)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer accepts connections on a local port. With dropFirst the first
+// connection is dropped right after MAIL FROM, later ones complete the session.
+// With authReply set AUTH is offered and answered with it.
+type fakeSmtpServer struct {
+	listener    net.Listener
+	dropFirst   bool
+	authReply   string
+	connections int32
+	delivered   chan string
+}
+
+func newFakeSmtpServer(t *testing.T, dropFirst bool, authReply string) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSmtpServer{listener: listener, dropFirst: dropFirst, authReply: authReply, delivered: make(chan string, 1)}
+	go server.serve()
+	return server
+}
+
+func (s *fakeSmtpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn, s.dropFirst && atomic.AddInt32(&s.connections, 1) == 1)
+	}
+}
+
+func (s *fakeSmtpServer) session(conn net.Conn, drop bool) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	_ = text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+		case "EHLO", "HELO":
+			if s.authReply != "" {
+				_ = text.PrintfLine("250-fake")
+				_ = text.PrintfLine("250 AUTH PLAIN")
+			} else {
+				_ = text.PrintfLine("250 fake")
+			}
+		case "AUTH":
+			_ = text.PrintfLine("%s", s.authReply)
+		case "MAIL":
+			if drop {
+				return
+			}
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			message, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.delivered <- strings.Join(message, "\n")
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func TestSendSmtpReconnectsAfterDroppedConnection(t *testing.T) {
+	server := newFakeSmtpServer(t, true, "")
+	defer server.listener.Close()
+
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	conf := Configuration{
+		SmtpHost:       host,
+		SmtpPort:       port,
+		SmtpSecurity:   "starttls",
+		SmtpRetryCount: 3,
+		SmtpRetryDelay: time.Millisecond,
+	}
+
+	err := SendSmtp(conf, "from@example.com", []string{"to@example.com"}, []byte("Subject: test\r\n\r\nhello\r\n"))
+	if err != nil {
+		t.Fatalf("SendSmtp: %s", err)
+	}
+
+	select {
+	case message := <-server.delivered:
+		if !strings.Contains(message, "hello") {
+			t.Errorf("delivered %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing delivered")
+	}
+
+	if connections := atomic.LoadInt32(&server.connections); connections != 2 {
+		t.Errorf("got %d connections, want exactly one reconnect", connections)
+	}
+}
+
+func TestSendSmtpAuthErrors(t *testing.T) {
+	tests := []struct {
+		reply     string
+		auth      bool
+		transient bool
+	}{
+		{reply: "535 5.7.8 bad credentials", auth: true},
+		{reply: "534 5.7.9 application password required", auth: true},
+		{reply: "454 4.7.0 temporary authentication failure", transient: true},
+		{reply: "421 4.7.0 try again later", transient: true},
+		{reply: "530 5.7.0 must issue STARTTLS first"},
+	}
+
+	for _, test := range tests {
+		server := newFakeSmtpServer(t, false, test.reply)
+		host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+		conf := Configuration{SmtpHost: host, SmtpPort: port, SmtpFromEmail: "from@example.com", SmtpPassword: "secret"}
+
+		err := sendSmtpOnce(conf, "from@example.com", []string{"to@example.com"}, []byte("Subject: test\r\n\r\nhello\r\n"))
+		server.listener.Close()
+		if err == nil {
+			t.Errorf("%s: no error", test.reply)
+			continue
+		}
+		if IsSmtpAuthError(err) != test.auth {
+			t.Errorf("%s: IsSmtpAuthError = %t, want %t", test.reply, !test.auth, test.auth)
+		}
+		if IsTransientSmtpError(err) != test.transient {
+			t.Errorf("%s: IsTransientSmtpError = %t, want %t", test.reply, !test.transient, test.transient)
+		}
+	}
+}
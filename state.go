@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is persisted between runs in the configured StateStore.
+type State struct {
+	Initialized        bool   `json:"initialized"`
+	LastCampaignId     string `json:"last_campaign_id"`
+	LastUrl            string `json:"last_url"`
+	LastWebId          int    `json:"last_web_id,omitempty"`
+	LastContentHash    string `json:"last_content_hash,omitempty"`
+	BaselineCampaignId string `json:"baseline_campaign_id,omitempty"`
+	BaselineUrl        string `json:"baseline_url,omitempty"`
+	LastStatus         string `json:"last_status,omitempty"`
+	// LastSmallCampaignId is the newest campaign skipped below MinEmailsSent that was notified
+	LastSmallCampaignId string `json:"last_small_campaign_id,omitempty"`
+	// History of sent campaigns for TrackHistory, read up to the HistoryCursor send_time
+	History       []HistoryEntry `json:"history,omitempty"`
+	HistoryCursor string         `json:"history_cursor,omitempty"`
+
+	// ShortUrls are the short URLs per link id seen by TrackShortUrl
+	ShortUrls map[string]string `json:"short_urls,omitempty"`
+
+	// RecreatedLinkIds maps configured link ids to the id RecreateMissingLink created
+	RecreatedLinkIds map[string]string `json:"recreated_link_ids,omitempty"`
+
+	// LinkCache holds the last read URL per link id, reused within LinkCacheTtl
+	LinkCache map[string]CachedLink `json:"link_cache,omitempty"`
+
+	// Notifications sent and dropped per channel, for MaxNotificationsPerHour
+	NotificationTimes    map[string][]time.Time `json:"notification_times,omitempty"`
+	DroppedNotifications map[string]int         `json:"dropped_notifications,omitempty"`
+}
+
+// CachedLink is a link URL as read at ReadAt
+type CachedLink struct {
+	Url    string    `json:"url"`
+	ReadAt time.Time `json:"read_at"`
+}
+
+// CachedLinkUrl returns the cached URL of linkId if it was read within ttl
+func (s State) CachedLinkUrl(linkId string, ttl time.Duration) (string, bool) {
+	cached, ok := s.LinkCache[linkId]
+	if !ok || ttl <= 0 || clock.Now().Sub(cached.ReadAt) >= ttl {
+		return "", false
+	}
+	return cached.Url, true
+}
+
+// LastStatus values
+const (
+	StatusOk    = "ok"
+	StatusError = "error"
+)
+
+// IsFirstRun reports whether no run has saved state yet. State files written before
+// Initialized existed count as initialized once they record a synced campaign.
+func (s State) IsFirstRun() bool {
+	return !s.Initialized && s.LastCampaignId == ""
+}
+
+// StateStore loads and saves the State between runs
+type StateStore interface {
+	Load() (State, error)
+	Save(state State) error
+}
+
+// NewStateStore returns the store selected by StateBackend, defaulting to the file
+func NewStateStore(conf Configuration) StateStore {
+	var store StateStore = FileStateStore{Path: conf.StateFile}
+	if conf.StateBackend == "redis" {
+		store = NewRedisStateStore(conf)
+	}
+
+	if simulating {
+		return SimulatedStateStore{StateStore: store}
+	}
+	return store
+}
+
+// FileStateStore keeps the state as JSON in a local file
+type FileStateStore struct {
+	Path string
+}
+
+func (s FileStateStore) Load() (State, error) {
+	return LoadState(s.Path)
+}
+
+func (s FileStateStore) Save(state State) error {
+	return SaveState(s.Path, state)
+}
+
+// RedisStateStore keeps the state as JSON under a single Redis key, so several
+// instances can share it. With a TTL the state expires if no run refreshes it.
+type RedisStateStore struct {
+	client *redis.Client
+	key    string
+	ttl    time.Duration
+}
+
+// redisTimeout bounds each Redis command so an unreachable server fails the run
+// rather than hanging it
+const redisTimeout = 10 * time.Second
+
+// redisClient is shared by every RedisStateStore of the process, as a store is made
+// several times per run and go-redis never closes idle pool connections itself
+var redisClient struct {
+	mu      sync.Mutex
+	client  *redis.Client
+	options redis.Options
+}
+
+// sharedRedisClient returns the process client for the configured server, replacing
+// it when a changed configuration points elsewhere
+func sharedRedisClient(conf Configuration) *redis.Client {
+	options := redis.Options{Addr: conf.RedisAddr, Password: conf.RedisPassword, DB: conf.RedisDb}
+
+	redisClient.mu.Lock()
+	defer redisClient.mu.Unlock()
+
+	if redisClient.client != nil && redisClient.options.Addr == options.Addr &&
+		redisClient.options.Password == options.Password && redisClient.options.DB == options.DB {
+		return redisClient.client
+	}
+
+	if redisClient.client != nil {
+		_ = redisClient.client.Close()
+	}
+	// NewClient fills in defaults on the options it gets, so it is given a copy
+	clientOptions := options
+	redisClient.client = redis.NewClient(&clientOptions)
+	redisClient.options = options
+	return redisClient.client
+}
+
+func NewRedisStateStore(conf Configuration) *RedisStateStore {
+	return &RedisStateStore{
+		client: sharedRedisClient(conf),
+		key:    conf.RedisKeyPrefix + "state",
+		ttl:    time.Duration(conf.RedisStateTtlSeconds) * time.Second,
+	}
+}
+
+func (s *RedisStateStore) Load() (State, error) {
+	state := State{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("reading state from redis: %w", err)
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (s *RedisStateStore) Save(state State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, s.key, data, s.ttl).Err(); err != nil {
+		return fmt.Errorf("writing state to redis: %w", err)
+	}
+	return nil
+}
+
+// LoadState reads the state file, returning an empty State if it does not exist yet.
+func LoadState(path string) (State, error) {
+	state := State{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// SaveState writes the state file via a temporary file and rename so an interrupted
+// run never leaves a truncated state behind.
+func SaveState(path string, state State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusSet is a set of HTTP status codes parsed from a list such as "200-299,304"
+type StatusSet struct {
+	raw    string
+	ranges [][2]int
+}
+
+var DefaultOkStatuses = StatusSet{raw: "200-299", ranges: [][2]int{{200, 299}}}
+
+func ParseStatusSet(value string) (StatusSet, error) {
+	set := StatusSet{raw: value}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := parseStatusCode(first)
+		if err != nil {
+			return set, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseStatusCode(last); err != nil {
+				return set, err
+			}
+			if to < from {
+				return set, fmt.Errorf("invalid status range %q", item)
+			}
+		}
+
+		set.ranges = append(set.ranges, [2]int{from, to})
+	}
+
+	if len(set.ranges) == 0 {
+		return set, fmt.Errorf("status list %q is empty", value)
+	}
+
+	return set, nil
+}
+
+func parseStatusCode(value string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("invalid HTTP status %q", value)
+	}
+	return code, nil
+}
+
+func (s StatusSet) Contains(code int) bool {
+	for _, r := range s.ranges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s StatusSet) String() string {
+	return s.raw
+}
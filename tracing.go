@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"net/url"
+	"time"
+)
+
+var tracer = otel.Tracer("MailchimpToWebsite")
+
+// shutdownTracing flushes any buffered spans. It is replaced by SetupTracing and
+// called by HandleError before exiting, so failed runs are still exported.
+var shutdownTracing = func() {}
+
+// SetupTracing installs an OTLP/HTTP exporter when OtelEndpoint is configured.
+// Without it the global no-op provider is left in place and spans cost nothing.
+// OtelEndpoint may be host:port (sent over TLS) or a full http(s) URL.
+func SetupTracing(conf Configuration) {
+	if conf.OtelEndpoint == "" {
+		return
+	}
+
+	options := []otlptracehttp.Option{}
+	if parsed, err := url.Parse(conf.OtelEndpoint); err == nil && parsed.Host != "" {
+		options = append(options, otlptracehttp.WithEndpoint(parsed.Host))
+		if parsed.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if parsed.Path != "" && parsed.Path != "/" {
+			options = append(options, otlptracehttp.WithURLPath(parsed.Path))
+		}
+	} else {
+		options = append(options, otlptracehttp.WithEndpoint(conf.OtelEndpoint))
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		log.Printf("Tracing disabled, could not create OTLP exporter: %s", err)
+		return
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "MailchimpToWebsite"),
+			attribute.String("service.instance.id", conf.InstanceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	shutdownTracing = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Flushing traces failed: %s", err)
+		}
+	}
+}
+
+// EndSpan records err on the span, if any, and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseLinkTransforms parses ";" separated link=template pairs for
+// LinkTransformTemplates, where link is a link id or a target name such as preview.
+// Templates may contain commas and "=", so only the first "=" separates the link.
+func ParseLinkTransforms(value string) (map[string]string, error) {
+	transforms := map[string]string{}
+
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		link, template, found := strings.Cut(item, "=")
+		link, template = strings.TrimSpace(link), strings.TrimSpace(template)
+		if !found || link == "" || template == "" {
+			return nil, fmt.Errorf("transform %q must be link=template", item)
+		}
+		if !strings.Contains(template, "{{url}}") && !strings.Contains(template, "{{url_escaped}}") {
+			return nil, fmt.Errorf("transform %q must contain {{url}} or {{url_escaped}}", item)
+		}
+
+		transforms[link] = template
+	}
+
+	return transforms, nil
+}
+
+// LinkTransformTemplate returns the template for target: its entry in
+// LinkTransformTemplates by link id or name, falling back to UrlTransformTemplate
+func LinkTransformTemplate(conf Configuration, target LinkTarget) string {
+	if template, ok := conf.LinkTransformTemplates[target.LinkId]; ok {
+		return template
+	}
+	if template, ok := conf.LinkTransformTemplates[target.Name]; ok {
+		return template
+	}
+	return conf.UrlTransformTemplate
+}
+
+// ApplyUrlTransform fills a transform template for the campaign URL of a link.
+// Placeholders are {{url}}, {{url_escaped}} (query escaped), {{campaign_id}},
+// {{web_id}} and {{link}}, the target name.
+func ApplyUrlTransform(template string, url string, result *Result, target LinkTarget) string {
+	return strings.NewReplacer(
+		"{{url}}", url,
+		"{{url_escaped}}", neturl.QueryEscape(url),
+		"{{campaign_id}}", result.LatestCampaignId,
+		"{{web_id}}", strconv.Itoa(result.LatestWebId),
+		"{{link}}", target.Name,
+	).Replace(template)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// fixedLinkService serves one URL for every link and records the ids read
+type fixedLinkService struct {
+	url   string
+	reads []string
+}
+
+func (s *fixedLinkService) Name() string { return "fixed" }
+
+func (s *fixedLinkService) GetURL(ctx context.Context, conf Configuration, result *Result, linkId string) (string, error) {
+	s.reads = append(s.reads, linkId)
+	return s.url, nil
+}
+
+func (s *fixedLinkService) UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error {
+	return nil
+}
+
+func TestSyncLinkKeepsTemplateOfRecreatedLink(t *testing.T) {
+	conf := Configuration{LinkTransformTemplates: map[string]string{"configured": "{{url}}?utm_source=site"}}
+	state := State{RecreatedLinkIds: map[string]string{"configured": "recreated"}}
+	campaign := &MailChimpCampaign{LongArchiveUrl: "https://example.com/campaign"}
+	service := &fixedLinkService{url: "https://example.com/old"}
+
+	link := SyncLink(context.Background(), conf, NewResult(), service, state, campaign, LinkTarget{Name: "primary", LinkId: "configured"})
+
+	if link.LinkId != "recreated" || len(service.reads) != 1 || service.reads[0] != "recreated" {
+		t.Errorf("read %v as link %s, want the recreated id", service.reads, link.LinkId)
+	}
+	if want := "https://example.com/campaign?utm_source=site"; link.LatestUrl != want {
+		t.Errorf("LatestUrl = %s, want %s", link.LatestUrl, want)
+	}
+}
+
+func TestSyncLinkComparesTransformTrackingParams(t *testing.T) {
+	conf := Configuration{IgnoreTrackingParams: true, UrlTransformTemplate: "{{url}}?utm_campaign={{web_id}}"}
+	campaign := &MailChimpCampaign{LongArchiveUrl: "https://example.com/campaign"}
+	service := &fixedLinkService{url: "https://example.com/campaign?utm_campaign=1"}
+	result := NewResult()
+	result.LatestWebId = 2
+
+	link := SyncLink(context.Background(), conf, result, service, State{}, campaign, LinkTarget{Name: "primary", LinkId: "link"})
+
+	if !link.UpdateRequired {
+		t.Errorf("%s and %s compared equal, the transform's utm_campaign must not be ignored", link.CurrentUrl, link.LatestUrl)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+type UrlDay struct {
+	Status int `json:"status"`
+	Data   struct {
+		Id       string `json:"id"`
+		Alias    string `json:"alias"`
+		Url      string `json:"url"`
+		ShortUrl string `json:"short_url"`
+	} `json:"data"`
+}
+
+type UrlDayLinks struct {
+	Data []struct {
+		Id    string `json:"id"`
+		Alias string `json:"alias"`
+	} `json:"data"`
+}
+
+// urlDayLinkIds maps configured link values to the id they resolved to, as a value
+// that is not found as an id is looked up as an alias instead. It lives as long as
+// the process, so a daemon resolves each alias once until the id stops existing.
+var urlDayLinkIds = map[string]string{}
+
+// UrlDayApiUrl builds an API URL from UrlDayBaseUrl, which defaults to the UrlDay API
+func UrlDayApiUrl(conf Configuration, path string) string {
+	base := conf.UrlDayBaseUrl
+	if base == "" {
+		base = "https://www.urlday.com/api/v1"
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func GetCurrentUrlDay(ctx context.Context, conf Configuration, result *Result, linkId string) (currentUrl string, err error) {
+	ctx, span := tracer.Start(ctx, "urlday-get", trace.WithAttributes(attribute.String("urlday.link_id", linkId)))
+	defer func() { EndSpan(span, err) }()
+
+	if id, ok := urlDayLinkIds[linkId]; ok {
+		urlday, err := getUrlDayLink(ctx, conf, result, id)
+		if !IsStatusError(err, http.StatusNotFound) {
+			result.RecordShortUrl(linkId, urlday.Data.ShortUrl)
+			return urlday.Data.Url, err
+		}
+
+		// The alias may have been moved to a new link, so resolve it again
+		log.Printf("UrlDay link %q resolved to id %s earlier, which no longer exists, resolving again", linkId, id)
+		delete(urlDayLinkIds, linkId)
+	}
+
+	// Both lookups go through SendRequest, so transient failures are retried with the
+	// RetryCount backoff instead of failing the run before any update is attempted
+	urlday, err := getUrlDayLink(ctx, conf, result, linkId)
+	if IsStatusError(err, http.StatusNotFound) {
+		id, aliasErr := FindUrlDayLinkByAlias(ctx, conf, result, linkId)
+		if aliasErr != nil {
+			return "", fmt.Errorf("UrlDay link %q not found as an id, and the alias lookup failed: %w", linkId, aliasErr)
+		}
+		if id == "" {
+			return "", fmt.Errorf("UrlDay link %q not found as an id or alias: %w", linkId, err)
+		}
+
+		log.Printf("UrlDay link %q not found as an id, resolved as an alias to id %s", linkId, id)
+		urlDayLinkIds[linkId] = id
+		urlday, err = getUrlDayLink(ctx, conf, result, id)
+	} else if err == nil {
+		log.Printf("UrlDay link %q resolved as an id", linkId)
+		urlDayLinkIds[linkId] = linkId
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result.RecordShortUrl(linkId, urlday.Data.ShortUrl)
+	return urlday.Data.Url, nil
+}
+
+func getUrlDayLink(ctx context.Context, conf Configuration, result *Result, linkId string) (UrlDay, error) {
+	urlday := UrlDay{}
+
+	url := UrlDayApiUrl(conf, "/links/"+linkId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return urlday, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+
+	bodyBytes, err := sendUrlDayRead(conf, result, req)
+	if err != nil {
+		return urlday, err
+	}
+
+	// Convert response body to UrlDay struct
+	err = json.Unmarshal(bodyBytes, &urlday)
+	return urlday, err
+}
+
+// FindUrlDayLinkByAlias searches the account links for an exact alias match and
+// returns its id, or an empty id if there is none
+func FindUrlDayLinkByAlias(ctx context.Context, conf Configuration, result *Result, alias string) (string, error) {
+	query := neturl.Values{"search": {alias}, "search_by": {"alias"}}
+	url := UrlDayApiUrl(conf, "/links?"+query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+
+	bodyBytes, err := sendUrlDayRead(conf, result, req)
+	if err != nil {
+		return "", err
+	}
+
+	links := UrlDayLinks{}
+	if err := json.Unmarshal(bodyBytes, &links); err != nil {
+		return "", err
+	}
+
+	for _, link := range links.Data {
+		if link.Alias == alias {
+			return link.Id, nil
+		}
+	}
+
+	return "", nil
+}
+
+// sendUrlDayRead performs a rate limited UrlDay GET and returns the JSON body
+func sendUrlDayRead(conf Configuration, result *Result, req *http.Request) ([]byte, error) {
+	urlDayLimiter.Wait(conf.UrlDayRateLimitThreshold)
+	resp, err := SendRequest(conf, result, "urlday-get", conf.UrlDayOkStatuses, req)
+	if err != nil {
+		return nil, err
+	}
+	urlDayLimiter.Observe(resp.Header)
+
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// An HTML error page from a proxy or maintenance mode would otherwise fail to
+	// decode with an unhelpful JSON syntax error
+	if conf.UrlDayRequireJson && !IsJsonContentType(resp.Header.Get("Content-Type")) {
+		return nil, fmt.Errorf("UrlDay returned %q instead of JSON (status %d): %s",
+			resp.Header.Get("Content-Type"), resp.StatusCode, BodySnippet(bodyBytes))
+	}
+
+	return bodyBytes, nil
+}
+
+// UpdateUrlDay points the link at urlUpdate. A 409 conflict means someone else
+// edited the link at the same time, so the link is re-read: if it already has our
+// URL, compared like a sync compares it, that counts as success, otherwise the update is retried up to
+// UrlDayConflictRetries times, UrlDayConflictDelay apart, rather than immediately.
+func UpdateUrlDay(ctx context.Context, conf Configuration, result *Result, linkId string, urlUpdate string) (err error) {
+	ctx, span := tracer.Start(ctx, "urlday-put", trace.WithAttributes(attribute.String("urlday.link_id", linkId)))
+	defer func() { EndSpan(span, err) }()
+
+	for attempt := 0; ; attempt++ {
+		err = putUrlDay(ctx, conf, result, linkId, urlUpdate)
+		if !IsStatusError(err, http.StatusConflict) {
+			return err
+		}
+
+		currentUrl, getErr := GetCurrentUrlDay(ctx, conf, result, linkId)
+		if getErr == nil && urlsEqual(conf, currentUrl, urlUpdate) {
+			log.Printf("UrlDay link %s update conflicted, but it already points to %s", linkId, urlUpdate)
+			return nil
+		}
+
+		if attempt >= conf.UrlDayConflictRetries {
+			return err
+		}
+
+		log.Printf("UrlDay link %s update conflicted, retrying in %s", linkId, conf.UrlDayConflictDelay)
+		select {
+		case <-clock.After(conf.UrlDayConflictDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func putUrlDay(ctx context.Context, conf Configuration, result *Result, linkId string, urlUpdate string) error {
+	if id, ok := urlDayLinkIds[linkId]; ok {
+		linkId = id
+	}
+
+	newUrlInfo := neturl.Values{"url": {urlUpdate}}.Encode()
+
+	url := UrlDayApiUrl(conf, "/links/"+linkId)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer([]byte(newUrlInfo)))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+
+	urlDayLimiter.Wait(conf.UrlDayRateLimitThreshold)
+	resp, err := SendRequest(conf, result, "urlday-put", conf.UrlDayOkStatuses, req)
+	if err != nil {
+		return err
+	}
+	urlDayLimiter.Observe(resp.Header)
+
+	return resp.Body.Close()
+}
+
+// CreateUrlDayLink creates a link to url, with alias when not empty, and returns
+// its id
+func CreateUrlDayLink(ctx context.Context, conf Configuration, result *Result, url string, alias string) (string, error) {
+	form := neturl.Values{"url": {url}}
+	if alias != "" {
+		form.Set("alias", alias)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", UrlDayApiUrl(conf, "/links"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Authorization", "Bearer "+conf.UrlDayApiKey)
+
+	urlDayLimiter.Wait(conf.UrlDayRateLimitThreshold)
+	resp, err := SendRequest(conf, result, "urlday-create", conf.UrlDayOkStatuses, req)
+	if err != nil {
+		return "", err
+	}
+	urlDayLimiter.Observe(resp.Header)
+	defer resp.Body.Close()
+
+	urlday := UrlDay{}
+	if err := json.NewDecoder(resp.Body).Decode(&urlday); err != nil {
+		return "", err
+	}
+	if urlday.Data.Id == "" {
+		return "", errors.New("UrlDay did not return the id of the created link")
+	}
+
+	return urlday.Data.Id, nil
+}
+
+// IsJsonContentType accepts application/json and any +json media type
+func IsJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeUrlDay serves a single link whose PUTs are answered with conflicts first
+type fakeUrlDay struct {
+	mu        sync.Mutex
+	url       string
+	conflicts int
+	puts      int
+	gets      int
+}
+
+func (f *fakeUrlDay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path != "/links/abc" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		f.gets++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":200,"data":{"id":"abc","url":"` + f.url + `"}}`))
+	case "PUT":
+		f.puts++
+		if f.conflicts > 0 {
+			f.conflicts--
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.url = r.PostFormValue("url")
+	}
+}
+
+func testUrlDayConfiguration(serverUrl string) Configuration {
+	return Configuration{
+		UrlDayBaseUrl:         serverUrl,
+		UrlDayApiKey:          "test",
+		UrlDayOkStatuses:      DefaultOkStatuses,
+		UrlDayConflictRetries: 3,
+	}
+}
+
+func TestUpdateUrlDayRetriesAfterConflict(t *testing.T) {
+	urlDayLinkIds = map[string]string{}
+	fake := &fakeUrlDay{url: "https://example.com/old", conflicts: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := testUrlDayConfiguration(server.URL)
+	if err := UpdateUrlDay(context.Background(), conf, NewResult(), "abc", "https://example.com/new"); err != nil {
+		t.Fatalf("UpdateUrlDay: %s", err)
+	}
+
+	if fake.puts != 2 || fake.gets != 1 {
+		t.Errorf("got %d PUTs and %d GETs, want 2 PUTs around 1 re-read", fake.puts, fake.gets)
+	}
+	if fake.url != "https://example.com/new" {
+		t.Errorf("link points to %s", fake.url)
+	}
+}
+
+func TestUpdateUrlDayConflictAlreadyUpdated(t *testing.T) {
+	urlDayLinkIds = map[string]string{}
+	fake := &fakeUrlDay{url: "https://example.com/new", conflicts: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := testUrlDayConfiguration(server.URL)
+	if err := UpdateUrlDay(context.Background(), conf, NewResult(), "abc", "https://example.com/new"); err != nil {
+		t.Fatalf("UpdateUrlDay: %s", err)
+	}
+
+	if fake.puts != 1 || fake.gets != 1 {
+		t.Errorf("got %d PUTs and %d GETs, want the re-read to end it after 1 PUT", fake.puts, fake.gets)
+	}
+}
+
+func TestUpdateUrlDayFormEncodesUrl(t *testing.T) {
+	urlDayLinkIds = map[string]string{}
+	fake := &fakeUrlDay{url: "https://example.com/old"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	newUrl := "https://example.com/new?utm_source=a&utm_medium=b+c#top"
+	conf := testUrlDayConfiguration(server.URL)
+	if err := UpdateUrlDay(context.Background(), conf, NewResult(), "abc", newUrl); err != nil {
+		t.Fatalf("UpdateUrlDay: %s", err)
+	}
+
+	if fake.url != newUrl {
+		t.Errorf("link points to %s, want %s", fake.url, newUrl)
+	}
+}
+
+func TestUpdateUrlDayConflictComparesLikeSync(t *testing.T) {
+	urlDayLinkIds = map[string]string{}
+	fake := &fakeUrlDay{url: "https://EXAMPLE.com/new?mc_cid=1", conflicts: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := testUrlDayConfiguration(server.URL)
+	conf.CompareHostCaseInsensitive, conf.IgnoreTrackingParams = true, true
+	if err := UpdateUrlDay(context.Background(), conf, NewResult(), "abc", "https://example.com/new"); err != nil {
+		t.Fatalf("UpdateUrlDay: %s", err)
+	}
+
+	if fake.puts != 1 || fake.gets != 1 {
+		t.Errorf("got %d PUTs and %d GETs, want the re-read to end it after 1 PUT", fake.puts, fake.gets)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultTimeout bounds each Vault request, as configuration cannot wait indefinitely
+const vaultTimeout = 10 * time.Second
+
+// vaultSession is the token used for Vault, from VaultToken or an AppRole login,
+// kept across daemon runs and renewed by RenewVaultToken
+var vaultSession struct {
+	mu        sync.Mutex
+	token     string
+	ttl       time.Duration
+	renewable bool
+}
+
+// vaultKeys are the keys set in the environment from Vault, which a later read
+// from Vault may replace while real environment variables always win
+var vaultKeys = map[string]bool{}
+
+// LoadVaultConfig reads the secret at VaultPath and sets every configuration key it
+// holds that is not already set by the environment or .env, so precedence is env,
+// then .env, then Vault, then the defaults. KV version 1 and 2 mounts both work;
+// for version 2 VaultPath includes data/, e.g. secret/data/mailchimptowebsite.
+func LoadVaultConfig(sources map[string]string) error {
+	addr := strings.TrimSuffix(strings.TrimSpace(os.Getenv("VaultAddr")), "/")
+	path := strings.Trim(strings.TrimSpace(os.Getenv("VaultPath")), "/")
+	if addr == "" || path == "" {
+		return errors.New("VaultAddr and VaultPath are required for ConfigSource=vault")
+	}
+
+	token, err := vaultToken(addr)
+	if err != nil {
+		return fmt.Errorf("Vault login failed: %w", err)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultRequest("GET", addr+"/v1/"+path, token, nil, &secret); err != nil {
+		return fmt.Errorf("reading Vault secret %s failed: %w", path, err)
+	}
+
+	values := secret.Data
+	if nested, ok := values["data"].(map[string]interface{}); ok {
+		if _, isKv2 := values["metadata"]; isKv2 {
+			values = nested
+		}
+	}
+
+	for _, key := range ConfigKeys() {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if _, set := sources[key]; set && !vaultKeys[key] {
+			continue
+		}
+
+		_ = os.Setenv(key, fmt.Sprint(value))
+		vaultKeys[key] = true
+		sources[key] = "vault"
+	}
+
+	return nil
+}
+
+// vaultToken returns the session token, logging in with VaultRoleId and
+// VaultSecretId through AppRole when VaultToken is not set
+func vaultToken(addr string) (string, error) {
+	vaultSession.mu.Lock()
+	defer vaultSession.mu.Unlock()
+
+	if vaultSession.token != "" {
+		return vaultSession.token, nil
+	}
+
+	if token := strings.TrimSpace(os.Getenv("VaultToken")); token != "" {
+		vaultSession.token, vaultSession.ttl, vaultSession.renewable = token, 0, true
+		return token, nil
+	}
+
+	roleId, secretId := strings.TrimSpace(os.Getenv("VaultRoleId")), strings.TrimSpace(os.Getenv("VaultSecretId"))
+	if roleId == "" {
+		return "", errors.New("VaultToken or VaultRoleId is required")
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+			Renewable     bool   `json:"renewable"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": roleId, "secret_id": secretId}
+	if err := vaultRequest("POST", addr+"/v1/auth/approle/login", "", body, &login); err != nil {
+		return "", err
+	}
+
+	vaultSession.token = login.Auth.ClientToken
+	vaultSession.ttl = time.Duration(login.Auth.LeaseDuration) * time.Second
+	vaultSession.renewable = login.Auth.Renewable
+	return vaultSession.token, nil
+}
+
+// RenewVaultToken extends the session token so a long running daemon does not lose
+// access between reads, returning the new TTL. A token that cannot be renewed is
+// dropped, so the next read logs in again with AppRole.
+func RenewVaultToken() (time.Duration, error) {
+	addr := strings.TrimSuffix(strings.TrimSpace(os.Getenv("VaultAddr")), "/")
+
+	vaultSession.mu.Lock()
+	defer vaultSession.mu.Unlock()
+
+	if vaultSession.token == "" || !vaultSession.renewable {
+		return 0, nil
+	}
+
+	var renewal struct {
+		Auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	if err := vaultRequest("POST", addr+"/v1/auth/token/renew-self", vaultSession.token, map[string]string{}, &renewal); err != nil {
+		vaultSession.token = ""
+		return 0, err
+	}
+
+	vaultSession.ttl = time.Duration(renewal.Auth.LeaseDuration) * time.Second
+	vaultSession.renewable = renewal.Auth.Renewable
+	return vaultSession.ttl, nil
+}
+
+// KeepVaultTokenRenewed renews the session token at half its TTL until ctx is done
+func KeepVaultTokenRenewed(ctx context.Context) {
+	for {
+		vaultSession.mu.Lock()
+		ttl := vaultSession.ttl
+		vaultSession.mu.Unlock()
+
+		// Tokens without a known TTL are checked hourly
+		wait := ttl / 2
+		if wait <= 0 {
+			wait = time.Hour
+		}
+
+		select {
+		case <-clock.After(wait):
+		case <-ctx.Done():
+			return
+		}
+
+		if ttl, err := RenewVaultToken(); err != nil {
+			log.Printf("Vault token renewal failed, logging in again on the next read: %s", err)
+		} else if ttl > 0 {
+			Debugf("Vault token renewed for %s", ttl)
+		}
+	}
+}
+
+func vaultRequest(method string, url string, token string, body interface{}, into interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := strings.TrimSpace(os.Getenv("VaultNamespace")); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Vault returned status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(into)
+}
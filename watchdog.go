@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hardTimeoutGrace is how long a run has to stop after its context is cancelled by
+// the watchdog before the process is killed
+const hardTimeoutGrace = 30 * time.Second
+
+// hardTimeoutExceeded is set once the watchdog cancels the current run, so the error
+// the run then fails with is reported as the hard timeout, see HandleError
+var hardTimeoutExceeded atomic.Bool
+
+// StartWatchdog returns a context for the run that is cancelled once the run takes
+// longer than HardTimeoutSeconds, and a function to call when the run is over. If
+// the run is still going hardTimeoutGrace after the cancellation, for example stuck
+// in a blocking syscall no context reaches, the error is notified and the process
+// exits.
+func StartWatchdog(ctx context.Context, conf Configuration) (context.Context, func()) {
+	hardTimeoutExceeded.Store(false)
+	if conf.HardTimeoutSeconds <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	timeout := time.Duration(conf.HardTimeoutSeconds) * time.Second
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-clock.After(timeout):
+		}
+
+		log.Printf("Run exceeded HardTimeoutSeconds %d, aborting it", conf.HardTimeoutSeconds)
+		hardTimeoutExceeded.Store(true)
+		cancel()
+
+		select {
+		case <-done:
+			return
+		case <-clock.After(hardTimeoutGrace):
+		}
+
+		err := fmt.Errorf("run exceeded hard timeout of %s and did not stop within %s", timeout, hardTimeoutGrace)
+		messages := LoadMessages(conf.NotifyLanguage)
+		_ = NotifyAll(context.Background(), conf, Notification{
+			Subject: messages.Format("subject_error"),
+			Body:    messages.Format("error_message", err.Error()) + "\r\n\r\n" + messages.Format("run_id", runId),
+			Level:   NotifyLevelError,
+			Outcome: OutcomeError,
+		})
+		PingMonitor(conf, false)
+		fatal(err)
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+		})
+	}
+}
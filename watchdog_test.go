@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatchdogCancelsRunAfterHardTimeout(t *testing.T) {
+	fake := NewFakeClock(time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC))
+	defer func(previous Clock) { clock = previous }(clock)
+	clock = fake
+
+	ctx, stop := StartWatchdog(context.Background(), Configuration{HardTimeoutSeconds: 60})
+	defer stop()
+
+	fake.BlockUntil(1)
+	fake.Advance(59 * time.Second)
+	if ctx.Err() != nil || hardTimeoutExceeded.Load() {
+		t.Fatal("run cancelled before HardTimeoutSeconds")
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("run not cancelled after HardTimeoutSeconds")
+	}
+	if !hardTimeoutExceeded.Load() {
+		t.Error("hard timeout not recorded")
+	}
+
+	// The run stopping within the grace period is the end of it, with no forced exit
+	fake.BlockUntil(1)
+	stop()
+}
+
+func TestWatchdogStopsWithTheRun(t *testing.T) {
+	fake := NewFakeClock(time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC))
+	defer func(previous Clock) { clock = previous }(clock)
+	clock = fake
+
+	ctx, stop := StartWatchdog(context.Background(), Configuration{HardTimeoutSeconds: 60})
+	fake.BlockUntil(1)
+	stop()
+
+	fake.Advance(time.Hour)
+	if hardTimeoutExceeded.Load() {
+		t.Error("hard timeout recorded for a run that finished")
+	}
+	if ctx.Err() == nil {
+		t.Error("run context not released by stop")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WebhookService mirrors to a generic REST endpoint. The link id is the endpoint
+// URL, which answers a GET with {"url": "..."} and accepts the same body as a PUT.
+// With WebhookSigningSecret set, updates carry an HMAC-SHA256 of the body as
+// sha256=<hex> in WebhookSignatureHeader.
+type WebhookService struct{}
+
+type webhookLinkBody struct {
+	Url string `json:"url"`
+}
+
+func (WebhookService) Name() string {
+	return "webhook"
+}
+
+func (WebhookService) GetURL(ctx context.Context, conf Configuration, result *Result, linkId string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", linkId, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := SendRequest(conf, result, "webhook-get", DefaultOkStatuses, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body := webhookLinkBody{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding webhook response: %w", err)
+	}
+
+	return body.Url, nil
+}
+
+func (WebhookService) UpdateURL(ctx context.Context, conf Configuration, result *Result, linkId string, url string) error {
+	body, err := json.Marshal(webhookLinkBody{Url: url})
+	if err != nil {
+		return err
+	}
+
+	return putWebhook(ctx, conf, result, linkId, body)
+}
+
+type webhookBatchBody struct {
+	Links []webhookBatchLink `json:"links"`
+}
+
+type webhookBatchLink struct {
+	Id  string `json:"id"`
+	Url string `json:"url"`
+}
+
+// UpdateURLs sends every update in one PUT to WebhookBatchUrl, or one PUT per link
+// endpoint when it is not set
+func (s WebhookService) UpdateURLs(ctx context.Context, conf Configuration, result *Result, updates []LinkUpdate) error {
+	if conf.WebhookBatchUrl == "" {
+		for _, update := range updates {
+			if err := s.UpdateURL(ctx, conf, result, update.LinkId, update.Url); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	batch := webhookBatchBody{}
+	for _, update := range updates {
+		batch.Links = append(batch.Links, webhookBatchLink{Id: update.LinkId, Url: update.Url})
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	return putWebhook(ctx, conf, result, conf.WebhookBatchUrl, body)
+}
+
+// putWebhook PUTs the JSON body to endpoint, signed with WebhookSigningSecret
+func putWebhook(ctx context.Context, conf Configuration, result *Result, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if conf.WebhookSigningSecret != "" {
+		req.Header.Set(conf.WebhookSignatureHeader, WebhookSignature(conf.WebhookSigningSecret, body))
+	}
+
+	resp, err := SendRequest(conf, result, "webhook-put", DefaultOkStatuses, req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// WebhookSignature is the sha256=<hex HMAC-SHA256> of body keyed with secret
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
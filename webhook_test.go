@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The example from GitHub's webhook signature documentation, which uses the same
+// sha256=<hex> format
+func TestWebhookSignatureKnownVector(t *testing.T) {
+	got := WebhookSignature("It's a Secret to Everybody", []byte("Hello, World!"))
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got != want {
+		t.Errorf("WebhookSignature = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookUpdateURLIsSigned(t *testing.T) {
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature-256")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	conf := Configuration{WebhookSigningSecret: "secret", WebhookSignatureHeader: "X-Signature-256"}
+	if err := (WebhookService{}).UpdateURL(context.Background(), conf, NewResult(), server.URL, "https://example.com/new"); err != nil {
+		t.Fatalf("UpdateURL: %s", err)
+	}
+
+	sent := webhookLinkBody{}
+	if err := json.Unmarshal(body, &sent); err != nil || sent.Url != "https://example.com/new" {
+		t.Fatalf("unexpected body %s", body)
+	}
+	if want := WebhookSignature("secret", body); signature != want {
+		t.Errorf("signature header = %q, want %q", signature, want)
+	}
+}
+
+func TestUpdateLinksBatchesWebhookUpdates(t *testing.T) {
+	var paths []string
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	updates := []LinkUpdate{
+		{LinkId: server.URL + "/primary", Url: "https://example.com/a"},
+		{LinkId: server.URL + "/preview", Url: "https://example.com/b"},
+	}
+
+	conf := Configuration{WebhookBatchUrl: server.URL + "/batch"}
+	if err := UpdateLinks(context.Background(), conf, NewResult(), WebhookService{}, updates); err != nil {
+		t.Fatalf("UpdateLinks: %s", err)
+	}
+	if len(paths) != 1 || paths[0] != "PUT /batch" {
+		t.Fatalf("got requests %v, want a single PUT /batch", paths)
+	}
+	sent := webhookBatchBody{}
+	if err := json.Unmarshal(bodies[0], &sent); err != nil || len(sent.Links) != 2 ||
+		sent.Links[0] != (webhookBatchLink{Id: updates[0].LinkId, Url: updates[0].Url}) ||
+		sent.Links[1] != (webhookBatchLink{Id: updates[1].LinkId, Url: updates[1].Url}) {
+		t.Errorf("unexpected batch body %s", bodies[0])
+	}
+
+	paths, bodies = nil, nil
+	if err := UpdateLinks(context.Background(), Configuration{}, NewResult(), WebhookService{}, updates); err != nil {
+		t.Fatalf("UpdateLinks without WebhookBatchUrl: %s", err)
+	}
+	if len(paths) != 2 || paths[0] != "PUT /primary" || paths[1] != "PUT /preview" {
+		t.Errorf("got requests %v, want one PUT per link", paths)
+	}
+}